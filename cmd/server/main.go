@@ -23,12 +23,12 @@ func main() {
 
 	log.Println("Configurations:")
 	log.Println("ServerPort:", configs.ServerPort)
-	log.Println("RateLimiter:", configs.RateLimiter)
-	log.Println("Persistence:", configs.Persistence)
+	log.Println("RateLimiter:", configs.RateLimiter.Redacted())
+	log.Println("Persistence:", configs.Persistence.Redacted())
 
 	webserver := webserver.NewWebServer(configs.ServerPort)
 
-	rateLimiterRepository := db.RateLimiterRepositoryStrategy(ctx, configs.Persistence, "redis")
+	rateLimiterRepository := db.RateLimiterRepositoryStrategy(ctx, configs.Persistence, configs.Persistence.Database)
 	rateLimiterMiddleware := web.NewRateLimiterMiddleware(ctx, configs.RateLimiter, rateLimiterRepository)
 	webserver.AddMiddleware(rateLimiterMiddleware.Handle)
 	homeHandler := web.NewHomeHandler()
@@ -42,4 +42,8 @@ func main() {
 	defer cancel()
 
 	webserver.Stop(ctx)
+
+	if err := rateLimiterMiddleware.RateLimiter.Close(ctx); err != nil {
+		log.Println("Could not persist active clients:", err)
+	}
 }
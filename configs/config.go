@@ -1,23 +1,129 @@
 package configs
 
 import (
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
+// PersistenceConfigs selects where the active clients are stored, redis
+// (default) or sqlite. SQLite is kept in memory unless Sqlite.Path is set
 type PersistenceConfigs struct {
+	Database string
+	Sqlite   struct {
+		Path string
+	}
 	Redis struct {
 		Addr     string
 		Password string
 		Db       int
+		Prefix   string
 	}
 }
 
+// TokenConfig holds the limits of a token or of a plan. Tokens referencing a
+// Plan get its settings, overridden by the ones set on the token
+type TokenConfig struct {
+	Plan             string
+	MaxReqsPerSecond int
+	Rate             string
+	Rates            []string
+	Burst            int
+	Algorithm        string
+	Window           time.Duration
+	BlockingDuration time.Duration
+	ExpiresAt        time.Time
+	MaxIps           int
+	MaxIpsWindow     time.Duration
+}
+
+// KeyExtractorConfig selects where the client key is read from. Name is the
+// header, query param, cookie, URL param or JWT claim name, depending on Type
+type KeyExtractorConfig struct {
+	Type string
+	Name string
+}
+
+// RoutePolicyConfig limits the requests matching the chi route Pattern and
+// Methods (any method when empty) apart, by its own limits, or exempts them.
+// Cost is how many requests each request counts as
+type RoutePolicyConfig struct {
+	Pattern     string
+	Methods     []string
+	Exempt      bool
+	Cost        int
+	TokenConfig `mapstructure:",squash"`
+}
+
+// KeyHashConfigs makes the token configs keyed by the hex digest of the keys,
+// SHA-256 (sha256) or HMAC-SHA-256 with the Pepper (hmac_sha256)
+type KeyHashConfigs struct {
+	Algorithm string
+	Pepper    string
+}
+
+// JwtConfigs enables JWT authenticated clients when Algorithm (HS256, RS256 or
+// ES256) is set. SubjectClaim identifies the client and PlanClaim selects its plan
+type JwtConfigs struct {
+	Algorithm     string
+	Secret        string
+	PublicKeyFile string
+	SubjectClaim  string
+	PlanClaim     string
+}
+
 type RateLimiterConfigs struct {
 	BlockingDuration   time.Duration
+	Algorithm          string
+	Window             time.Duration
 	IpMaxReqsPerSecond int
-	TokenConfigs       map[string]int
+	IpRate             string
+	IpRates            []string
+	IpBurst            int
+	IpV4Prefix         int
+	IpV6Prefix         int
+	TokenConfigs       map[string]TokenConfig
+	Plans              map[string]TokenConfig
+	ExpiredTokenPolicy string
+	UnknownKeyPolicy   string
+	UnknownKeyLimit    TokenConfig
+	EnforceIpLimit     bool
+	Jwt                JwtConfigs
+	IetfHeaders        bool
+	TrustedProxies     []string
+	ClientIpHeader     string
+	KeyExtractors      []KeyExtractorConfig
+	KeyHash            KeyHashConfigs
+	Routes             []RoutePolicyConfig
+	MaxWait            time.Duration
+	MaxQueue           int
+	Distributed        bool
+	PersistInterval    time.Duration
+	PersistBatchSize   int
+}
+
+// Redacted returns a copy of the configs safe to log, with the secrets masked
+func (c PersistenceConfigs) Redacted() PersistenceConfigs {
+	c.Redis.Password = redact(c.Redis.Password)
+	return c
+}
+
+// Redacted returns a copy of the configs safe to log, with the secrets masked
+func (c RateLimiterConfigs) Redacted() RateLimiterConfigs {
+	c.KeyHash.Pepper = redact(c.KeyHash.Pepper)
+	c.Jwt.Secret = redact(c.Jwt.Secret)
+	return c
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[REDACTED]"
 }
 
 type Conf struct {
@@ -37,9 +143,39 @@ func LoadConfig(path string) (*Conf, error) {
 	if err != nil {
 		panic(err)
 	}
-	err = viper.Unmarshal(&cfg)
+	err = viper.Unmarshal(&cfg, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+		mapstructure.StringToSliceHookFunc(","),
+		tokenConfigHookFunc(),
+	)))
 	if err != nil {
 		panic(err)
 	}
 	return cfg, err
 }
+
+// tokenConfigHookFunc supports the short "token: maxReqsPerSecond", "token: rate" and "token: plan" forms
+func tokenConfigHookFunc() mapstructure.DecodeHookFuncType {
+	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+		if to != reflect.TypeOf(TokenConfig{}) {
+			return data, nil
+		}
+
+		switch from.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return TokenConfig{MaxReqsPerSecond: int(reflect.ValueOf(data).Int())}, nil
+		case reflect.String:
+			if strings.Contains(data.(string), "/") {
+				return TokenConfig{Rate: data.(string)}, nil
+			}
+			maxReqsPerSecond, err := strconv.Atoi(data.(string))
+			if err != nil {
+				return TokenConfig{Plan: data.(string)}, nil
+			}
+			return TokenConfig{MaxReqsPerSecond: maxReqsPerSecond}, nil
+		}
+
+		return data, nil
+	}
+}
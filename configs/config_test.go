@@ -0,0 +1,36 @@
+package configs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+}
+
+func TestConfigSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
+
+func (suite *ConfigTestSuite) TestGivenSecrets_WhenRedacted_ThenShouldMaskThemWithoutChangingTheConfigs() {
+
+	rateLimiter := RateLimiterConfigs{
+		KeyHash: KeyHashConfigs{Algorithm: "hmac_sha256", Pepper: "pepper-secret"},
+		Jwt:     JwtConfigs{Algorithm: "HS256", Secret: "jwt-secret"},
+	}
+	var persistence PersistenceConfigs
+	persistence.Redis.Password = "redis-secret"
+
+	logged := fmt.Sprint(rateLimiter.Redacted(), persistence.Redacted())
+
+	suite.NotContains(logged, "pepper-secret")
+	suite.NotContains(logged, "jwt-secret")
+	suite.NotContains(logged, "redis-secret")
+	suite.Contains(logged, "hmac_sha256")
+	suite.Equal("pepper-secret", rateLimiter.KeyHash.Pepper)
+	suite.Equal("jwt-secret", rateLimiter.Jwt.Secret)
+	suite.Equal("redis-secret", persistence.Redis.Password)
+}
@@ -2,8 +2,6 @@ package entity
 
 import (
 	"time"
-
-	"golang.org/x/time/rate"
 )
 
 type ClientType uint8
@@ -11,13 +9,18 @@ type ClientType uint8
 const (
 	Ip ClientType = iota
 	Token
+	// UnknownKey clients are the IPs sending keys limited by the unknown key limit
+	UnknownKey
 )
 
 type ActiveClient struct {
-	ClientId     string        `json:"clientId"`
-	LastSeen     time.Time     `json:"lastSeen"`
-	ClientType   ClientType    `json:"clientType"`
-	BlockedUntil time.Time     `json:"blockedUntil"`
-	Blocked      bool          `json:"blocked"`
-	Limiter      *rate.Limiter `json:"-"`
+	ClientId     string     `json:"clientId"`
+	LastSeen     time.Time  `json:"lastSeen"`
+	ClientType   ClientType `json:"clientType"`
+	Plan         string     `json:"plan,omitempty"`
+	Policy       string     `json:"policy,omitempty"`
+	BlockedUntil time.Time  `json:"blockedUntil"`
+	Blocked      bool       `json:"blocked"`
+	BlockedBy    string     `json:"blockedBy,omitempty"`
+	LimiterState string     `json:"limiterState,omitempty"`
 }
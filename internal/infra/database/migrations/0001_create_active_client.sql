@@ -0,0 +1,11 @@
+CREATE TABLE active_client (
+    ClientId TEXT NOT NULL PRIMARY KEY,
+    LastSeen DATETIME NOT NULL,
+    ClientType INTEGER NOT NULL,
+    Plan TEXT,
+    Policy TEXT,
+    BlockedUntil DATETIME,
+    Blocked BOOLEAN NOT NULL,
+    BlockedBy TEXT,
+    LimiterState TEXT
+);
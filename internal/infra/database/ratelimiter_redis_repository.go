@@ -2,73 +2,217 @@ package database
 
 import (
 	"context"
-	"encoding/json"
 	"log"
+	"strconv"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
 )
 
+// inactiveClientTTL keeps a client stored for as long as the rate limiter
+// keeps it active without requests
+const inactiveClientTTL = 3 * time.Minute
+
+// RateLimiterRedisRepository stores each client in the hash <prefix>:client:<id>,
+// expiring when the client is neither blocked nor active
 type RateLimiterRedisRepository struct {
-	ctx    context.Context
 	client *redis.Client
+	prefix string
+}
+
+func NewRateLimiterRedisRepository(client *redis.Client, prefix string) *RateLimiterRedisRepository {
+	return &RateLimiterRedisRepository{client: client, prefix: prefix}
+}
+
+// Client is the Redis connection, shared with the distributed limiter
+func (r *RateLimiterRedisRepository) Client() *redis.Client {
+	return r.client
 }
 
-func NewRateLimiterRedisRepository(ctx context.Context, client *redis.Client) *RateLimiterRedisRepository {
-	return &RateLimiterRedisRepository{ctx: ctx, client: client}
+// Prefix namespaces every key of the rate limiter
+func (r *RateLimiterRedisRepository) Prefix() string {
+	return r.prefix
 }
 
-func (r *RateLimiterRedisRepository) SaveActiveClients(clients map[string]entity.ActiveClient) error {
+func (r *RateLimiterRedisRepository) clientKey(id string) string {
+	return r.prefix + ":client:" + id
+}
 
-	value, err := json.Marshal(clients)
+func (r *RateLimiterRedisRepository) Get(ctx context.Context, id string) (entity.ActiveClient, error) {
+	value, err := r.client.HGetAll(ctx, r.clientKey(id)).Result()
 	if err != nil {
-		log.Println("Error marshalling clients to JSON", err)
-		return err
+		return entity.ActiveClient{}, err
 	}
+	if len(value) == 0 {
+		return entity.ActiveClient{}, ErrClientNotFound
+	}
+	return fromRedisHash(value)
+}
 
-	for _, client := range clients {
-		err = r.client.Set(r.ctx, client.ClientId, value, 0).Err()
+func (r *RateLimiterRedisRepository) GetMany(ctx context.Context, ids []string) (map[string]entity.ActiveClient, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = r.clientKey(id)
+	}
+	return r.getKeys(ctx, keys)
+}
+
+// getKeys reads the client hashes in a single round trip, skipping the
+// missing and undecodable ones
+func (r *RateLimiterRedisRepository) getKeys(ctx context.Context, keys []string) (map[string]entity.ActiveClient, error) {
+	activeClients := make(map[string]entity.ActiveClient, len(keys))
+	if len(keys) == 0 {
+		return activeClients, nil
+	}
+
+	cmds := make([]*redis.StringStringMapCmd, len(keys))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error getting active clients from Redis", err)
+		return activeClients, err
+	}
+
+	for i, cmd := range cmds {
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+		activeClient, err := fromRedisHash(cmd.Val())
 		if err != nil {
-			log.Println("Error saving active client to Redis", err)
+			log.Println("Error decoding active client from Redis", keys[i], err)
+			continue
 		}
+		activeClients[activeClient.ClientId] = activeClient
 	}
+	return activeClients, nil
+}
 
-	return err
+func (r *RateLimiterRedisRepository) Put(ctx context.Context, client entity.ActiveClient) error {
+	return r.PutMany(ctx, []entity.ActiveClient{client})
 }
 
-func (r *RateLimiterRedisRepository) GetActiveClients() (map[string]entity.ActiveClient, error) {
+func (r *RateLimiterRedisRepository) PutMany(ctx context.Context, clients []entity.ActiveClient) error {
+	now := time.Now()
 
-	activeClients := make(map[string]entity.ActiveClient, 0)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, client := range clients {
+			key := r.clientKey(client.ClientId)
+			pipe.Del(ctx, key)
+			pipe.HSet(ctx, key, toRedisHash(client))
+			pipe.PExpire(ctx, key, clientTTL(client, now))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error saving active clients to Redis", err)
+	}
+	return err
+}
 
-	keys := make([]string, 0)
+func (r *RateLimiterRedisRepository) Delete(ctx context.Context, id string) error {
+	return r.DeleteMany(ctx, []string{id})
+}
 
-	iter := r.client.Scan(r.ctx, 0, "", 0).Iterator()
-	for iter.Next(r.ctx) {
-		keys = append(keys, iter.Val())
+func (r *RateLimiterRedisRepository) DeleteMany(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
 	}
-	if err := iter.Err(); err != nil {
-		panic(err)
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = r.clientKey(id)
 	}
+	err := r.client.Del(ctx, keys...).Err()
+	if err != nil {
+		log.Println("Error deleting active clients from Redis", err)
+	}
+	return err
+}
 
-	for _, key := range keys {
-		value, err := r.client.Get(r.ctx, key).Result()
-		if err == redis.Nil {
-			log.Println("Error getting active client from Redis. Key does not exist", key)
-			continue
-		} else if err != nil {
-			log.Println("Error getting active client from Redis", err)
-			continue
+// Iterate scans the client keys only, leaving other keys of a shared Redis
+// untouched, and reads the clients a page of keys at a time
+func (r *RateLimiterRedisRepository) Iterate(ctx context.Context, fn func(client entity.ActiveClient) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, r.clientKey("*"), 100).Result()
+		if err != nil {
+			log.Println("Error scanning active clients on Redis", err)
+			return err
 		}
 
-		var activeClient map[string]entity.ActiveClient
-		err = json.Unmarshal([]byte(value), &activeClient)
+		activeClients, err := r.getKeys(ctx, keys)
 		if err != nil {
-			log.Println("Error unmarshalling active client from Redis", err)
-			continue
+			return err
+		}
+		for _, activeClient := range activeClients {
+			if err := fn(activeClient); err != nil {
+				return err
+			}
 		}
 
-		activeClients[key] = activeClient[key]
+		if cursor = next; cursor == 0 {
+			return nil
+		}
 	}
+}
 
-	return activeClients, nil
+// clientTTL keeps the client until its blocking ends or it becomes inactive
+func clientTTL(client entity.ActiveClient, now time.Time) time.Duration {
+	expiresAt := client.LastSeen.Add(inactiveClientTTL)
+	if client.Blocked && client.BlockedUntil.After(expiresAt) {
+		expiresAt = client.BlockedUntil
+	}
+	if ttl := expiresAt.Sub(now); ttl > time.Second {
+		return ttl
+	}
+	return time.Second
+}
+
+func toRedisHash(client entity.ActiveClient) map[string]interface{} {
+	return map[string]interface{}{
+		"clientId":     client.ClientId,
+		"lastSeen":     client.LastSeen.Format(time.RFC3339Nano),
+		"clientType":   int(client.ClientType),
+		"plan":         client.Plan,
+		"policy":       client.Policy,
+		"blockedUntil": client.BlockedUntil.Format(time.RFC3339Nano),
+		"blocked":      client.Blocked,
+		"blockedBy":    client.BlockedBy,
+		"limiterState": client.LimiterState,
+	}
+}
+
+func fromRedisHash(value map[string]string) (entity.ActiveClient, error) {
+	lastSeen, err := time.Parse(time.RFC3339Nano, value["lastSeen"])
+	if err != nil {
+		return entity.ActiveClient{}, err
+	}
+	blockedUntil, err := time.Parse(time.RFC3339Nano, value["blockedUntil"])
+	if err != nil {
+		return entity.ActiveClient{}, err
+	}
+	clientType, err := strconv.Atoi(value["clientType"])
+	if err != nil {
+		return entity.ActiveClient{}, err
+	}
+	blocked, err := strconv.ParseBool(value["blocked"])
+	if err != nil {
+		return entity.ActiveClient{}, err
+	}
+
+	return entity.ActiveClient{
+		ClientId:     value["clientId"],
+		LastSeen:     lastSeen,
+		ClientType:   entity.ClientType(clientType),
+		Plan:         value["plan"],
+		Policy:       value["policy"],
+		BlockedUntil: blockedUntil,
+		Blocked:      blocked,
+		BlockedBy:    value["blockedBy"],
+		LimiterState: value["limiterState"],
+	}, nil
 }
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimiterRedisRepositoryTestSuite struct {
+	suite.Suite
+	Ctx        context.Context
+	Now        time.Time
+	Server     *miniredis.Miniredis
+	Client     *redis.Client
+	Repository *RateLimiterRedisRepository
+}
+
+func (suite *RateLimiterRedisRepositoryTestSuite) SetupTest() {
+	suite.Ctx = context.Background()
+	suite.Now = time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	suite.Server = miniredis.RunT(suite.T())
+	suite.Client = redis.NewClient(&redis.Options{Addr: suite.Server.Addr()})
+	suite.Repository = NewRateLimiterRedisRepository(suite.Client, "test")
+}
+
+func (suite *RateLimiterRedisRepositoryTestSuite) TearDownTest() {
+	suite.Client.Close()
+}
+
+func TestRateLimiterRedisRepositorySuite(t *testing.T) {
+	suite.Run(t, new(RateLimiterRedisRepositoryTestSuite))
+}
+
+func (suite *RateLimiterRedisRepositoryTestSuite) TestGivenActiveClient_WhenEncodedAsHash_ThenShouldDecodeSameClient() {
+
+	client := entity.ActiveClient{
+		ClientId:     "abc123",
+		LastSeen:     suite.Now.Add(123 * time.Nanosecond),
+		ClientType:   entity.Token,
+		Plan:         "pro",
+		Policy:       "POST /login",
+		BlockedUntil: suite.Now.Add(30 * time.Second),
+		Blocked:      true,
+		BlockedBy:    "10/s",
+		LimiterState: "2024-07-01T12:00:01Z",
+	}
+
+	hash := make(map[string]string)
+	for field, value := range toRedisHash(client) {
+		hash[field] = fmt.Sprint(value)
+	}
+
+	decoded, err := fromRedisHash(hash)
+	suite.NoError(err)
+	suite.Equal(client.ClientId, decoded.ClientId)
+	suite.True(client.LastSeen.Equal(decoded.LastSeen))
+	suite.True(client.BlockedUntil.Equal(decoded.BlockedUntil))
+	client.LastSeen, client.BlockedUntil = decoded.LastSeen, decoded.BlockedUntil
+	suite.Equal(client, decoded)
+}
+
+func (suite *RateLimiterRedisRepositoryTestSuite) TestGivenActiveClient_WhenClientTTL_ThenShouldOutliveBlockingAndInactivity() {
+
+	client := entity.ActiveClient{ClientId: "127.0.0.1", LastSeen: suite.Now}
+	suite.Equal(inactiveClientTTL, clientTTL(client, suite.Now))
+
+	client.Blocked = true
+	client.BlockedUntil = suite.Now.Add(10 * time.Minute)
+	suite.Equal(10*time.Minute, clientTTL(client, suite.Now))
+
+	suite.Equal(time.Second, clientTTL(client, suite.Now.Add(time.Hour)))
+}
+
+func (suite *RateLimiterRedisRepositoryTestSuite) TestGivenClients_WhenPutMany_ThenShouldStoreThemUnderThePrefixWithTTL() {
+
+	now := time.Now()
+	active := entity.ActiveClient{ClientId: "127.0.0.1", LastSeen: now, ClientType: entity.Ip}
+	blocked := entity.ActiveClient{ClientId: "abc123", LastSeen: now, ClientType: entity.Token, Plan: "pro",
+		Blocked: true, BlockedUntil: now.Add(10 * time.Minute), BlockedBy: "10/s"}
+
+	suite.NoError(suite.Repository.PutMany(suite.Ctx, []entity.ActiveClient{active, blocked}))
+
+	suite.ElementsMatch([]string{"test:client:127.0.0.1", "test:client:abc123"}, suite.Server.Keys())
+	suite.Equal("pro", suite.Server.HGet("test:client:abc123", "plan"))
+	suite.InDelta(inactiveClientTTL, suite.Server.TTL("test:client:127.0.0.1"), float64(time.Second))
+	suite.InDelta(10*time.Minute, suite.Server.TTL("test:client:abc123"), float64(time.Second))
+
+	suite.Server.FastForward(inactiveClientTTL)
+	suite.Equal([]string{"test:client:abc123"}, suite.Server.Keys())
+
+	other := NewRateLimiterRedisRepository(suite.Client, "other")
+	_, err := other.Get(suite.Ctx, "abc123")
+	suite.ErrorIs(err, ErrClientNotFound)
+}
+
+func (suite *RateLimiterRedisRepositoryTestSuite) TestGivenStoredClients_WhenGetAndGetMany_ThenShouldReadThem() {
+
+	now := time.Now()
+	suite.NoError(suite.Repository.PutMany(suite.Ctx, []entity.ActiveClient{
+		{ClientId: "127.0.0.1", LastSeen: now, ClientType: entity.Ip},
+		{ClientId: "abc123", LastSeen: now, ClientType: entity.Token, Plan: "pro"},
+	}))
+
+	client, err := suite.Repository.Get(suite.Ctx, "abc123")
+	suite.NoError(err)
+	suite.Equal("abc123", client.ClientId)
+	suite.Equal(entity.Token, client.ClientType)
+	suite.Equal("pro", client.Plan)
+	suite.True(now.Equal(client.LastSeen))
+
+	_, err = suite.Repository.Get(suite.Ctx, "unknown")
+	suite.ErrorIs(err, ErrClientNotFound)
+
+	clients, err := suite.Repository.GetMany(suite.Ctx, []string{"127.0.0.1", "abc123", "unknown"})
+	suite.NoError(err)
+	suite.Equal(2, len(clients))
+	suite.Equal(entity.Ip, clients["127.0.0.1"].ClientType)
+	suite.Equal("pro", clients["abc123"].Plan)
+
+	suite.NoError(suite.Repository.DeleteMany(suite.Ctx, []string{"127.0.0.1"}))
+	clients, err = suite.Repository.GetMany(suite.Ctx, []string{"127.0.0.1", "abc123"})
+	suite.NoError(err)
+	suite.Equal(1, len(clients))
+}
+
+func (suite *RateLimiterRedisRepositoryTestSuite) TestGivenSharedRedis_WhenIterate_ThenShouldScanOnlyTheClientsOfThePrefix() {
+
+	now := time.Now()
+	clients := make([]entity.ActiveClient, 0, 150)
+	for i := 0; i < 150; i++ {
+		clients = append(clients, entity.ActiveClient{ClientId: fmt.Sprintf("10.0.0.%d", i), LastSeen: now, ClientType: entity.Ip})
+	}
+	suite.NoError(suite.Repository.PutMany(suite.Ctx, clients))
+
+	other := NewRateLimiterRedisRepository(suite.Client, "other")
+	suite.NoError(other.Put(suite.Ctx, entity.ActiveClient{ClientId: "127.0.0.1", LastSeen: now, ClientType: entity.Ip}))
+	suite.Server.Set("test:limiter:10.0.0.1", "foreign")
+	suite.Server.Set("session:abc", "foreign")
+	suite.Server.HSet("test:clientele", "clientId", "foreign")
+
+	seen := make(map[string]entity.ActiveClient)
+	suite.NoError(suite.Repository.Iterate(suite.Ctx, func(client entity.ActiveClient) error {
+		seen[client.ClientId] = client
+		return nil
+	}))
+	suite.Equal(150, len(seen))
+	suite.Contains(seen, "10.0.0.149")
+	suite.NotContains(seen, "127.0.0.1")
+
+	activeClients, err := GetActiveClients(suite.Ctx, other)
+	suite.NoError(err)
+	suite.Equal(1, len(activeClients))
+}
@@ -1,8 +1,34 @@
 package database
 
-import entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+import (
+	"context"
+	"errors"
 
+	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+)
+
+// ErrClientNotFound is returned by Get when the client is not stored
+var ErrClientNotFound = errors.New("active client not found")
+
+// RateLimiterRepository stores the active clients one by one, so persisting a
+// request costs the same whatever the number of clients. GetMany skips the
+// clients not stored, and Iterate stops at the first error returned by fn
 type RateLimiterRepository interface {
-	GetActiveClients() (map[string]entity.ActiveClient, error)
-	SaveActiveClients(clients map[string]entity.ActiveClient) error
+	Get(ctx context.Context, id string) (entity.ActiveClient, error)
+	GetMany(ctx context.Context, ids []string) (map[string]entity.ActiveClient, error)
+	Put(ctx context.Context, client entity.ActiveClient) error
+	PutMany(ctx context.Context, clients []entity.ActiveClient) error
+	Delete(ctx context.Context, id string) error
+	DeleteMany(ctx context.Context, ids []string) error
+	Iterate(ctx context.Context, fn func(client entity.ActiveClient) error) error
+}
+
+// GetActiveClients loads every stored client, keyed by ClientId
+func GetActiveClients(ctx context.Context, repository RateLimiterRepository) (map[string]entity.ActiveClient, error) {
+	activeClients := make(map[string]entity.ActiveClient)
+	err := repository.Iterate(ctx, func(client entity.ActiveClient) error {
+		activeClients[client.ClientId] = client
+		return nil
+	})
+	return activeClients, err
 }
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
 
 	"github.com/go-redis/redis/v8"
@@ -13,11 +14,11 @@ func RateLimiterRepositoryStrategy(ctx context.Context, configs config.Persisten
 	var repository RateLimiterRepository
 	switch database {
 	case "redis":
-		repository = NewRateLimiterRedisRepository(ctx, getRedisClient(ctx, configs))
+		repository = NewRateLimiterRedisRepository(getRedisClient(ctx, configs), getRedisPrefix(configs))
 	case "sqlite":
-		repository = NewRateLimiterSQLiteRepository(ctx, getSQLiteClient())
+		repository = NewRateLimiterSQLiteRepository(getSQLiteClient(ctx, configs))
 	default:
-		repository = NewRateLimiterRedisRepository(ctx, getRedisClient(ctx, configs))
+		repository = NewRateLimiterRedisRepository(getRedisClient(ctx, configs), getRedisPrefix(configs))
 	}
 	return repository
 }
@@ -38,10 +39,37 @@ func getRedisClient(ctx context.Context, configs config.PersistenceConfigs) *red
 	return redisClient
 }
 
-func getSQLiteClient() *sql.DB {
-	db, err := sql.Open("sqlite3", ":memory:")
+// getRedisPrefix namespaces the keys of the rate limiter, "ratelimiter" by default
+func getRedisPrefix(configs config.PersistenceConfigs) string {
+	if configs.Redis.Prefix == "" {
+		return "ratelimiter"
+	}
+	return configs.Redis.Prefix
+}
+
+// getSQLiteClient opens the database file at the configured path in WAL mode,
+// or an in-memory database when no path is set, and migrates its schema
+func getSQLiteClient(ctx context.Context, configs config.PersistenceConfigs) *sql.DB {
+	path := configs.Sqlite.Path
+	dsn := ":memory:"
+	if path != "" {
+		dsn = fmt.Sprintf("file:%s?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000", path)
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		panic(err)
 	}
+	if path == "" {
+		// every connection to :memory: would open a database of its own
+		db.SetMaxOpenConns(1)
+	}
+
+	err = MigrateSQLite(ctx, db)
+	if err != nil {
+		panic(err)
+	}
+	log.Println("SQLite connected", dsn)
+
 	return db
 }
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"time"
 
 	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
@@ -10,57 +11,158 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+const selectActiveClient = "SELECT ClientId, LastSeen, ClientType, Plan, Policy, BlockedUntil, Blocked, BlockedBy, LimiterState FROM active_client"
+
+const upsertActiveClient = `INSERT INTO active_client (ClientId, LastSeen, ClientType, Plan, Policy, BlockedUntil, Blocked, BlockedBy, LimiterState)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (ClientId) DO UPDATE SET
+	LastSeen = excluded.LastSeen,
+	ClientType = excluded.ClientType,
+	Plan = excluded.Plan,
+	Policy = excluded.Policy,
+	BlockedUntil = excluded.BlockedUntil,
+	Blocked = excluded.Blocked,
+	BlockedBy = excluded.BlockedBy,
+	LimiterState = excluded.LimiterState`
+
+// RateLimiterSQLiteRepository stores the active clients in the active_client
+// table created by MigrateSQLite, one row per ClientId
 type RateLimiterSQLiteRepository struct {
-	ctx    context.Context
 	client *sql.DB
 }
 
-func NewRateLimiterSQLiteRepository(ctx context.Context, client *sql.DB) *RateLimiterSQLiteRepository {
-	return &RateLimiterSQLiteRepository{ctx: ctx, client: client}
+func NewRateLimiterSQLiteRepository(client *sql.DB) *RateLimiterSQLiteRepository {
+	return &RateLimiterSQLiteRepository{client: client}
 }
 
-func (r *RateLimiterSQLiteRepository) SaveActiveClients(clients map[string]entity.ActiveClient) error {
+func (r *RateLimiterSQLiteRepository) Get(ctx context.Context, id string) (entity.ActiveClient, error) {
+	activeClient, err := scanActiveClient(r.client.QueryRowContext(ctx, selectActiveClient+" WHERE ClientId = ?", id))
+	if err == sql.ErrNoRows {
+		return activeClient, ErrClientNotFound
+	}
+	return activeClient, err
+}
 
-	for _, client := range clients {
+func (r *RateLimiterSQLiteRepository) GetMany(ctx context.Context, ids []string) (map[string]entity.ActiveClient, error) {
+	activeClients := make(map[string]entity.ActiveClient, len(ids))
+	if len(ids) == 0 {
+		return activeClients, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	rows, err := r.client.QueryContext(ctx, selectActiveClient+" WHERE ClientId IN ("+placeholders+")", args...)
+	if err != nil {
+		return activeClients, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		activeClient, err := scanActiveClient(rows)
+		if err != nil {
+			return activeClients, err
+		}
+		activeClients[activeClient.ClientId] = activeClient
+	}
+	return activeClients, rows.Err()
+}
+
+func (r *RateLimiterSQLiteRepository) Put(ctx context.Context, client entity.ActiveClient) error {
+	return r.PutMany(ctx, []entity.ActiveClient{client})
+}
+
+func (r *RateLimiterSQLiteRepository) PutMany(ctx context.Context, clients []entity.ActiveClient) error {
+	tx, err := r.client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		_, err := r.client.Exec("INSERT INTO active_client (ClientId, LastSeen, ClientType, BlockedUntil, Blocked) VALUES (?, ?, ?, ?, ?)",
-			client.ClientId, client.LastSeen, client.ClientType, client.BlockedUntil, client.Blocked)
+	stmt, err := tx.PrepareContext(ctx, upsertActiveClient)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, client := range clients {
+		_, err = stmt.ExecContext(ctx, client.ClientId, client.LastSeen, client.ClientType, client.Plan, client.Policy, client.BlockedUntil, client.Blocked, client.BlockedBy, client.LimiterState)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return tx.Commit()
 }
 
-func (r *RateLimiterSQLiteRepository) GetActiveClients() (map[string]entity.ActiveClient, error) {
+func (r *RateLimiterSQLiteRepository) Delete(ctx context.Context, id string) error {
+	return r.DeleteMany(ctx, []string{id})
+}
 
-	activeClients := make(map[string]entity.ActiveClient, 0)
+func (r *RateLimiterSQLiteRepository) DeleteMany(ctx context.Context, ids []string) error {
+	tx, err := r.client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	rows, err := r.client.Query("SELECT ClientId, LastSeen, ClientType, BlockedUntil, Blocked FROM active_client")
+	for _, id := range ids {
+		if _, err = tx.ExecContext(ctx, "DELETE FROM active_client WHERE ClientId = ?", id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *RateLimiterSQLiteRepository) Iterate(ctx context.Context, fn func(client entity.ActiveClient) error) error {
+	rows, err := r.client.QueryContext(ctx, selectActiveClient)
 	if err != nil {
-		return activeClients, err
+		return err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var clientId string
-		var lastSeen time.Time
-		var clientType int
-		var blockedUntil time.Time
-		var blocked bool
-
-		err = rows.Scan(&clientId, &lastSeen, &clientType, &blockedUntil, &blocked)
+		activeClient, err := scanActiveClient(rows)
 		if err != nil {
-			return activeClients, err
+			return err
 		}
-		activeClients[clientId] = entity.ActiveClient{
-			ClientId:     clientId,
-			LastSeen:     lastSeen,
-			ClientType:   entity.ClientType(clientType),
-			BlockedUntil: blockedUntil,
-			Blocked:      blocked,
+		if err = fn(activeClient); err != nil {
+			return err
 		}
 	}
-	defer rows.Close()
+	return rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
 
-	return activeClients, nil
+func scanActiveClient(row scanner) (entity.ActiveClient, error) {
+	var clientId string
+	var lastSeen time.Time
+	var clientType int
+	var plan sql.NullString
+	var policy sql.NullString
+	var blockedUntil time.Time
+	var blocked bool
+	var blockedBy sql.NullString
+	var limiterState sql.NullString
+
+	err := row.Scan(&clientId, &lastSeen, &clientType, &plan, &policy, &blockedUntil, &blocked, &blockedBy, &limiterState)
+	if err != nil {
+		return entity.ActiveClient{}, err
+	}
+	return entity.ActiveClient{
+		ClientId:     clientId,
+		LastSeen:     lastSeen,
+		ClientType:   entity.ClientType(clientType),
+		Plan:         plan.String,
+		Policy:       policy.String,
+		BlockedUntil: blockedUntil,
+		Blocked:      blocked,
+		BlockedBy:    blockedBy.String,
+		LimiterState: limiterState.String,
+	}, nil
 }
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "github.com/regismartiny/go-expert-desafio-rate-limiter/configs"
+	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimiterSQLiteRepositoryTestSuite struct {
+	suite.Suite
+	Ctx        context.Context
+	Db         *sql.DB
+	Repository *RateLimiterSQLiteRepository
+}
+
+func (suite *RateLimiterSQLiteRepositoryTestSuite) SetupTest() {
+	suite.Ctx = context.Background()
+	client, err := sql.Open("sqlite3", ":memory:")
+	suite.NoError(err)
+	client.SetMaxOpenConns(1)
+	suite.NoError(MigrateSQLite(suite.Ctx, client))
+	suite.Db = client
+	suite.Repository = NewRateLimiterSQLiteRepository(suite.Db)
+}
+
+func (suite *RateLimiterSQLiteRepositoryTestSuite) TearDownTest() {
+	suite.Db.Close()
+}
+
+func TestRateLimiterSQLiteRepositorySuite(t *testing.T) {
+	suite.Run(t, new(RateLimiterSQLiteRepositoryTestSuite))
+}
+
+func (suite *RateLimiterSQLiteRepositoryTestSuite) TestGivenClients_WhenPutGetAndDelete_ThenShouldKeepOneRowPerClient() {
+
+	now := time.Now().UTC()
+	suite.NoError(suite.Repository.PutMany(suite.Ctx, []entity.ActiveClient{
+		{ClientId: "127.0.0.1", LastSeen: now, ClientType: entity.Ip},
+		{ClientId: "abc123", LastSeen: now, ClientType: entity.Token, Plan: "pro"},
+	}))
+	suite.NoError(suite.Repository.Put(suite.Ctx, entity.ActiveClient{ClientId: "abc123", LastSeen: now, ClientType: entity.Token, Blocked: true, BlockedBy: "10/s"}))
+
+	client, err := suite.Repository.Get(suite.Ctx, "abc123")
+	suite.NoError(err)
+	suite.True(client.Blocked)
+	suite.Equal("10/s", client.BlockedBy)
+	suite.Empty(client.Plan)
+
+	clients, err := suite.Repository.GetMany(suite.Ctx, []string{"127.0.0.1", "abc123", "missing"})
+	suite.NoError(err)
+	suite.Len(clients, 2)
+
+	all, err := GetActiveClients(suite.Ctx, suite.Repository)
+	suite.NoError(err)
+	suite.Len(all, 2)
+
+	suite.NoError(suite.Repository.Delete(suite.Ctx, "abc123"))
+	_, err = suite.Repository.Get(suite.Ctx, "abc123")
+	suite.ErrorIs(err, ErrClientNotFound)
+
+	suite.NoError(suite.Repository.DeleteMany(suite.Ctx, []string{"127.0.0.1"}))
+	all, err = GetActiveClients(suite.Ctx, suite.Repository)
+	suite.NoError(err)
+	suite.Empty(all)
+}
+
+func (suite *RateLimiterSQLiteRepositoryTestSuite) TestGivenClientSavedTwice_WhenPut_ThenShouldUpsertTheRow() {
+
+	now := time.Now().UTC()
+	suite.NoError(suite.Repository.Put(suite.Ctx, entity.ActiveClient{ClientId: "abc123", LastSeen: now, ClientType: entity.Token}))
+	suite.NoError(suite.Repository.Put(suite.Ctx, entity.ActiveClient{ClientId: "abc123", LastSeen: now, ClientType: entity.Token, Plan: "pro"}))
+
+	var rows int
+	suite.NoError(suite.Db.QueryRow("SELECT COUNT(*) FROM active_client WHERE ClientId = ?", "abc123").Scan(&rows))
+	suite.Equal(1, rows)
+
+	client, err := suite.Repository.Get(suite.Ctx, "abc123")
+	suite.NoError(err)
+	suite.Equal("pro", client.Plan)
+}
+
+func (suite *RateLimiterSQLiteRepositoryTestSuite) TestGivenMigratedDatabase_WhenMigrateSQLiteAgain_ThenShouldKeepTheSchemaVersion() {
+
+	suite.NoError(MigrateSQLite(suite.Ctx, suite.Db))
+
+	var version, applied int
+	suite.NoError(suite.Db.QueryRow("SELECT MAX(Version), COUNT(*) FROM schema_migrations").Scan(&version, &applied))
+	loaded, err := loadMigrations()
+	suite.NoError(err)
+	suite.Equal(loaded[len(loaded)-1].version, version)
+	suite.Equal(len(loaded), applied)
+}
+
+func (suite *RateLimiterSQLiteRepositoryTestSuite) TestGivenSqlitePath_WhenReopened_ThenShouldKeepTheClientsInWALMode() {
+
+	var persistence config.PersistenceConfigs
+	persistence.Sqlite.Path = filepath.Join(suite.T().TempDir(), "ratelimiter.db")
+
+	client := getSQLiteClient(suite.Ctx, persistence)
+	var journalMode string
+	suite.NoError(client.QueryRow("PRAGMA journal_mode").Scan(&journalMode))
+	suite.Equal("wal", journalMode)
+	suite.NoError(NewRateLimiterSQLiteRepository(client).Put(suite.Ctx, entity.ActiveClient{ClientId: "127.0.0.1", LastSeen: time.Now().UTC(), ClientType: entity.Ip}))
+	suite.NoError(client.Close())
+
+	reopened := getSQLiteClient(suite.Ctx, persistence)
+	defer reopened.Close()
+	activeClients, err := GetActiveClients(suite.Ctx, NewRateLimiterSQLiteRepository(reopened))
+	suite.NoError(err)
+	suite.Contains(activeClients, "127.0.0.1")
+}
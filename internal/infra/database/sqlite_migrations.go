@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrations are named <version>_<description>.sql and applied in version order
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+type migration struct {
+	version int
+	name    string
+	script  string
+}
+
+// MigrateSQLite applies the migrations newer than the schema version of the
+// database, each one in its own transaction, recording them in schema_migrations
+func MigrateSQLite(ctx context.Context, client *sql.DB) error {
+	_, err := client.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (Version INTEGER NOT NULL PRIMARY KEY, Name TEXT NOT NULL, AppliedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = client.QueryRowContext(ctx, "SELECT COALESCE(MAX(Version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	pending, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		if migration.version <= current {
+			continue
+		}
+		log.Println("Applying migration", migration.name)
+		if err := applyMigration(ctx, client, migration); err != nil {
+			return fmt.Errorf("migration %s: %w", migration.name, err)
+		}
+	}
+	return nil
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no version", entry.Name())
+		}
+		script, err := migrations.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, migration{version: version, name: name, script: string(script)})
+	}
+
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].version < loaded[j].version })
+	return loaded, nil
+}
+
+func applyMigration(ctx context.Context, client *sql.DB, migration migration) error {
+	tx, err := client.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, migration.script); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (Version, Name) VALUES (?, ?)", migration.version, migration.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
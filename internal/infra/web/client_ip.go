@@ -0,0 +1,147 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Headers the trusted proxies may report the client IP in
+const (
+	ForwardedIPHeader     = "Forwarded"
+	XForwardedForIPHeader = "X-Forwarded-For"
+	XRealIPHeader         = "X-Real-IP"
+)
+
+// ClientIPExtractor finds the client IP of a request. Only the header set by
+// the trusted proxies is read, and only when the request comes from one of
+// them. Its hops are walked from the right until the first one that is not a
+// trusted proxy, so addresses spoofed by the client are never used
+type ClientIPExtractor struct {
+	trustedProxies []*net.IPNet
+	header         string
+}
+
+// NewClientIPExtractor reads the client IP from header, X-Forwarded-For by default
+func NewClientIPExtractor(trustedProxies []string, header string) *ClientIPExtractor {
+	extractor := &ClientIPExtractor{
+		trustedProxies: make([]*net.IPNet, 0, len(trustedProxies)),
+		header:         parseIPHeader(header),
+	}
+
+	for _, trustedProxy := range trustedProxies {
+		if !strings.Contains(trustedProxy, "/") {
+			if ip := net.ParseIP(trustedProxy); ip != nil && ip.To4() != nil {
+				trustedProxy += "/32"
+			} else {
+				trustedProxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(trustedProxy)
+		if err != nil {
+			panic(fmt.Errorf("invalid trusted proxy %q: %w", trustedProxy, err))
+		}
+		extractor.trustedProxies = append(extractor.trustedProxies, network)
+	}
+
+	return extractor
+}
+
+func (e *ClientIPExtractor) GetIP(req *http.Request) (string, error) {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		log.Printf("userip: %q is not IP:port\n", req.RemoteAddr)
+		return "", err
+	}
+
+	userIP := net.ParseIP(ip)
+	if userIP == nil {
+		log.Printf("userip: %q is not IP:port\n", req.RemoteAddr)
+		return "", fmt.Errorf("userip: %q is not IP:port", req.RemoteAddr)
+	}
+
+	if !e.isTrusted(userIP) {
+		return userIP.String(), nil
+	}
+
+	// walk the hops from the closest proxy to the client
+	hops := forwardedHops(req, e.header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHop(hops[i])
+		if hop == nil {
+			log.Printf("Invalid forwarded hop %q. Using %s\n", hops[i], userIP)
+			break
+		}
+		userIP = hop
+		if !e.isTrusted(userIP) {
+			break
+		}
+	}
+
+	log.Printf("IP: %s Forwarded for: %v\n", userIP, hops)
+
+	return userIP.String(), nil
+}
+
+func (e *ClientIPExtractor) isTrusted(ip net.IP) bool {
+	for _, network := range e.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseIPHeader(header string) string {
+	switch {
+	case header == "" || strings.EqualFold(header, XForwardedForIPHeader):
+		return XForwardedForIPHeader
+	case strings.EqualFold(header, ForwardedIPHeader):
+		return ForwardedIPHeader
+	case strings.EqualFold(header, XRealIPHeader):
+		return XRealIPHeader
+	}
+	panic(fmt.Errorf("invalid client IP header %q: expected %s, %s or %s", header, ForwardedIPHeader, XForwardedForIPHeader, XRealIPHeader))
+}
+
+// forwardedHops returns the addresses of the given header, the RFC 7239
+// Forwarded, X-Forwarded-For or X-Real-IP, ordered from the client to the
+// closest proxy
+func forwardedHops(req *http.Request, header string) []string {
+	hops := make([]string, 0)
+
+	switch header {
+	case ForwardedIPHeader:
+		for _, value := range req.Header.Values(ForwardedIPHeader) {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+					if found && strings.EqualFold(key, "for") {
+						hops = append(hops, strings.Trim(value, `"`))
+					}
+				}
+			}
+		}
+	case XForwardedForIPHeader:
+		for _, value := range req.Header.Values(XForwardedForIPHeader) {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	case XRealIPHeader:
+		if realIP := strings.TrimSpace(req.Header.Get(XRealIPHeader)); realIP != "" {
+			hops = append(hops, realIP)
+		}
+	}
+	return hops
+}
+
+// parseHop accepts IPs optionally with port, IPv6 in brackets as in the Forwarded header
+func parseHop(hop string) net.IP {
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
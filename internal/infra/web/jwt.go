@@ -0,0 +1,217 @@
+package web
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	configs "github.com/regismartiny/go-expert-desafio-rate-limiter/configs"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// JwtVerifier validates JWTs locally, with a shared secret (HS256) or the
+// public key of the issuer (RS256, ES256)
+type JwtVerifier struct {
+	algorithm    string
+	secret       []byte
+	publicKey    crypto.PublicKey
+	subjectClaim string
+	planClaim    string
+}
+
+// NewJwtVerifier returns nil when no algorithm is configured
+func NewJwtVerifier(config configs.JwtConfigs) *JwtVerifier {
+	if config.Algorithm == "" {
+		return nil
+	}
+
+	verifier := &JwtVerifier{
+		algorithm:    config.Algorithm,
+		subjectClaim: config.SubjectClaim,
+		planClaim:    config.PlanClaim,
+	}
+	if verifier.subjectClaim == "" {
+		verifier.subjectClaim = "sub"
+	}
+	if verifier.planClaim == "" {
+		verifier.planClaim = "plan"
+	}
+
+	switch config.Algorithm {
+	case HS256:
+		if config.Secret == "" {
+			panic(errors.New("jwt secret is required for HS256"))
+		}
+		verifier.secret = []byte(config.Secret)
+	case RS256, ES256:
+		publicKey, err := loadPublicKey(config.PublicKeyFile)
+		if err != nil {
+			panic(err)
+		}
+		_, isRSA := publicKey.(*rsa.PublicKey)
+		_, isECDSA := publicKey.(*ecdsa.PublicKey)
+		if (config.Algorithm == RS256 && !isRSA) || (config.Algorithm == ES256 && !isECDSA) {
+			panic(fmt.Errorf("jwt public key does not match algorithm %s", config.Algorithm))
+		}
+		verifier.publicKey = publicKey
+	default:
+		panic(fmt.Errorf("unsupported jwt algorithm %q", config.Algorithm))
+	}
+	return verifier
+}
+
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading jwt public key: %w", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("jwt public key %s is not PEM encoded", path)
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return certificate.PublicKey, nil
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
+// Verify checks the signature and the exp and nbf claims, returning the claims
+func (v *JwtVerifier) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt header: %w", err)
+	}
+	if err := json.Unmarshal(headerJson, &header); err != nil {
+		return nil, fmt.Errorf("malformed jwt header: %w", err)
+	}
+	if header.Alg != v.algorithm {
+		return nil, fmt.Errorf("unexpected jwt algorithm %q", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt signature: %w", err)
+	}
+	if err := v.verifySignature(parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims, err := decodeJwtClaims(token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	if exp, ok := claims["exp"].(float64); ok && now >= int64(exp) {
+		return nil, errors.New("jwt expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf) {
+		return nil, errors.New("jwt not valid yet")
+	}
+
+	return claims, nil
+}
+
+// Subject returns the claim identifying the client
+func (v *JwtVerifier) Subject(claims map[string]any) string {
+	return claimString(claims, v.subjectClaim)
+}
+
+// Plan returns the claim selecting the plan of the client
+func (v *JwtVerifier) Plan(claims map[string]any) string {
+	return claimString(claims, v.planClaim)
+}
+
+func (v *JwtVerifier) verifySignature(signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch v.algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("invalid jwt signature")
+		}
+	case RS256:
+		if err := rsa.VerifyPKCS1v15(v.publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid jwt signature")
+		}
+	case ES256:
+		// JWS encodes the ECDSA signature as the fixed size concatenation of r and s
+		if len(signature) != 64 {
+			return errors.New("invalid jwt signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(v.publicKey.(*ecdsa.PublicKey), digest[:], r, s) {
+			return errors.New("invalid jwt signature")
+		}
+	}
+	return nil
+}
+
+// decodeJwtClaims decodes the payload of a compact JWT
+func decodeJwtClaims(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed jwt")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt payload: %w", err)
+	}
+
+	claims := make(map[string]any)
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed jwt claims: %w", err)
+	}
+	return claims, nil
+}
+
+func claimString(claims map[string]any, claim string) string {
+	switch value := claims[claim].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
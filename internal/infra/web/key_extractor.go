@@ -0,0 +1,179 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	configs "github.com/regismartiny/go-expert-desafio-rate-limiter/configs"
+)
+
+const (
+	HeaderKeyExtractor   = "header"
+	BearerKeyExtractor   = "bearer"
+	QueryKeyExtractor    = "query"
+	CookieKeyExtractor   = "cookie"
+	UrlParamKeyExtractor = "url_param"
+	JwtClaimKeyExtractor = "jwt_claim"
+)
+
+// KeyExtractor finds the client key (the token) of a request. An empty key
+// means the request has none
+type KeyExtractor interface {
+	Extract(r *http.Request) string
+}
+
+// KeyExtractorChain tries each extractor in order, returning the first key found
+type KeyExtractorChain []KeyExtractor
+
+func (c KeyExtractorChain) Extract(r *http.Request) string {
+	for _, extractor := range c {
+		if key := extractor.Extract(r); key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+// NewKeyExtractorChain builds the configured extractors. jwt verifies the
+// tokens of the jwt_claim extractors, which require it
+func NewKeyExtractorChain(keyExtractors []configs.KeyExtractorConfig, jwt *JwtVerifier) KeyExtractorChain {
+	if len(keyExtractors) == 0 {
+		return KeyExtractorChain{HeaderExtractor{Name: "API_KEY"}}
+	}
+
+	chain := make(KeyExtractorChain, 0, len(keyExtractors))
+	for _, keyExtractor := range keyExtractors {
+		chain = append(chain, KeyExtractorStrategy(keyExtractor, jwt))
+	}
+	return chain
+}
+
+func KeyExtractorStrategy(config configs.KeyExtractorConfig, jwt *JwtVerifier) KeyExtractor {
+	var extractor KeyExtractor
+	switch config.Type {
+	case HeaderKeyExtractor:
+		extractor = HeaderExtractor{Name: config.Name}
+	case BearerKeyExtractor:
+		extractor = BearerExtractor{}
+	case QueryKeyExtractor:
+		extractor = QueryExtractor{Name: config.Name}
+	case CookieKeyExtractor:
+		extractor = CookieExtractor{Name: config.Name}
+	case UrlParamKeyExtractor:
+		extractor = UrlParamExtractor{Name: config.Name}
+	case JwtClaimKeyExtractor:
+		if jwt == nil {
+			panic(errors.New("jwt_claim key extractor requires the jwt configs"))
+		}
+		claim := config.Name
+		if claim == "" {
+			claim = "sub"
+		}
+		extractor = JwtClaimExtractor{Claim: claim, Verifier: jwt}
+	default:
+		panic(fmt.Errorf("unknown key extractor type %q", config.Type))
+	}
+	return extractor
+}
+
+type HeaderExtractor struct {
+	Name string
+}
+
+func (e HeaderExtractor) Extract(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get(e.Name))
+}
+
+// BearerExtractor reads the token of the "Authorization: Bearer <token>" header
+type BearerExtractor struct{}
+
+func (e BearerExtractor) Extract(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+type QueryExtractor struct {
+	Name string
+}
+
+func (e QueryExtractor) Extract(r *http.Request) string {
+	return r.URL.Query().Get(e.Name)
+}
+
+type CookieExtractor struct {
+	Name string
+}
+
+func (e CookieExtractor) Extract(r *http.Request) string {
+	cookie, err := r.Cookie(e.Name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// UrlParamExtractor reads a chi URL param. Middlewares run before routing, so
+// the route is matched here to resolve the params
+type UrlParamExtractor struct {
+	Name string
+}
+
+func (e UrlParamExtractor) Extract(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	if value := rctx.URLParam(e.Name); value != "" {
+		return value
+	}
+
+	routeCtx := matchRoute(r)
+	if routeCtx == nil {
+		return ""
+	}
+	return routeCtx.URLParam(e.Name)
+}
+
+// JwtClaimExtractor reads a claim of the bearer JWT, once verified, so forged
+// tokens can not pick the key of another client
+type JwtClaimExtractor struct {
+	Claim    string
+	Verifier *JwtVerifier
+}
+
+func (e JwtClaimExtractor) Extract(r *http.Request) string {
+	token := BearerExtractor{}.Extract(r)
+	if token == "" {
+		return ""
+	}
+	claims, err := e.Verifier.Verify(token)
+	if err != nil {
+		return ""
+	}
+	return claimString(claims, e.Claim)
+}
+
+// matchRoute resolves the chi route of the request, returning nil when not routed by chi
+func matchRoute(r *http.Request) *chi.Context {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return nil
+	}
+
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+
+	routeCtx := chi.NewRouteContext()
+	if !rctx.Routes.Match(routeCtx, r.Method, path) {
+		return nil
+	}
+	return routeCtx
+}
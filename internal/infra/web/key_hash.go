@@ -0,0 +1,54 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+
+	configs "github.com/regismartiny/go-expert-desafio-rate-limiter/configs"
+)
+
+const (
+	Sha256KeyHash     = "sha256"
+	HmacSha256KeyHash = "hmac_sha256"
+)
+
+// HashedKeyExtractor replaces the key found by the Extractor with its hex
+// digest, so raw keys are never looked up, stored or logged
+type HashedKeyExtractor struct {
+	Extractor KeyExtractor
+	Hash      func() hash.Hash
+}
+
+func (e HashedKeyExtractor) Extract(r *http.Request) string {
+	key := e.Extractor.Extract(r)
+	if key == "" {
+		return ""
+	}
+	h := e.Hash()
+	h.Write([]byte(key))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewHashedKeyExtractor wraps the extractor with the configured key hash. An
+// empty algorithm keeps the raw keys
+func NewHashedKeyExtractor(extractor KeyExtractor, config configs.KeyHashConfigs) KeyExtractor {
+	switch config.Algorithm {
+	case "":
+		return extractor
+	case Sha256KeyHash:
+		return HashedKeyExtractor{Extractor: extractor, Hash: sha256.New}
+	case HmacSha256KeyHash:
+		if config.Pepper == "" {
+			panic(fmt.Errorf("key hash %q requires a pepper", config.Algorithm))
+		}
+		return HashedKeyExtractor{Extractor: extractor, Hash: func() hash.Hash {
+			return hmac.New(sha256.New, []byte(config.Pepper))
+		}}
+	default:
+		panic(fmt.Errorf("unknown key hash algorithm %q", config.Algorithm))
+	}
+}
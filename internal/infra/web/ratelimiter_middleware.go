@@ -4,16 +4,33 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"time"
 
 	configs "github.com/regismartiny/go-expert-desafio-rate-limiter/configs"
 	db "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/infra/database"
 	rateLimiter "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/ratelimiter"
 )
 
+type decisionKey struct{}
+
+// DecisionFromContext returns the decision of the rate limiter on the request,
+// letting handlers know the limit applied and why a key was not used
+func DecisionFromContext(ctx context.Context) (rateLimiter.Decision, bool) {
+	decision, ok := ctx.Value(decisionKey{}).(rateLimiter.Decision)
+	return decision, ok
+}
+
 type RateLimiterMiddleware struct {
 	RateLimiter *rateLimiter.RateLimiter
+	ClientIP    *ClientIPExtractor
+	ClientKey   KeyExtractor
+	Jwt         *JwtVerifier
+	IetfHeaders bool
+	Routes      RoutePolicies
 }
 
 func NewRateLimiterMiddleware(
@@ -21,62 +38,235 @@ func NewRateLimiterMiddleware(
 	Configs configs.RateLimiterConfigs,
 	Repository db.RateLimiterRepository,
 ) *RateLimiterMiddleware {
+	routes, policies := getRoutePolicies(Configs.Routes, Configs.Plans)
+	jwt := NewJwtVerifier(Configs.Jwt)
 	return &RateLimiterMiddleware{
 		RateLimiter: rateLimiter.NewRateLimiter(
 			Ctx,
 			rateLimiter.RateLimiterConfigs{
 				BlockingDuration:   Configs.BlockingDuration,
+				Algorithm:          Configs.Algorithm,
+				Window:             Configs.Window,
 				IpMaxReqsPerSecond: Configs.IpMaxReqsPerSecond,
-				TokenConfigs:       Configs.TokenConfigs},
+				IpRate:             parseRate(Configs.IpRate),
+				IpRates:            parseRates(Configs.IpRates),
+				IpBurst:            Configs.IpBurst,
+				IpV4Prefix:         Configs.IpV4Prefix,
+				IpV6Prefix:         Configs.IpV6Prefix,
+				TokenConfigs:       getTokenConfigs(Configs.TokenConfigs, Configs.Plans),
+				Plans:              getTokenConfigs(Configs.Plans, nil),
+				ExpiredTokenPolicy: parseKeyPolicy(Configs.ExpiredTokenPolicy),
+				UnknownKeyPolicy:   parseKeyPolicy(Configs.UnknownKeyPolicy),
+				UnknownKeyLimit:    getUnknownKeyLimit(Configs),
+				EnforceIpLimit:     Configs.EnforceIpLimit,
+				Policies:           policies,
+				MaxWait:            Configs.MaxWait,
+				MaxQueue:           Configs.MaxQueue,
+				Distributed:        getDistributedLimiter(Configs, Repository),
+				PersistInterval:    Configs.PersistInterval,
+				PersistBatchSize:   Configs.PersistBatchSize},
 			Repository),
+		ClientIP:    NewClientIPExtractor(Configs.TrustedProxies, Configs.ClientIpHeader),
+		ClientKey:   NewHashedKeyExtractor(NewKeyExtractorChain(Configs.KeyExtractors, jwt), Configs.KeyHash),
+		Jwt:         jwt,
+		IetfHeaders: Configs.IetfHeaders,
+		Routes:      routes,
+	}
+}
+
+// getTokenConfigs converts token or plan configs, validating their plans exist
+func getTokenConfigs(configs map[string]configs.TokenConfig, plans map[string]configs.TokenConfig) map[string]rateLimiter.TokenConfig {
+	tokenConfigs := make(map[string]rateLimiter.TokenConfig, len(configs))
+	for token, tokenConfig := range configs {
+		tokenConfigs[token] = getTokenConfig(tokenConfig, plans)
+	}
+	return tokenConfigs
+}
+
+func getTokenConfig(tokenConfig configs.TokenConfig, plans map[string]configs.TokenConfig) rateLimiter.TokenConfig {
+	if _, ok := plans[tokenConfig.Plan]; tokenConfig.Plan != "" && !ok {
+		panic(fmt.Errorf("unknown plan %q", tokenConfig.Plan))
+	}
+	return rateLimiter.TokenConfig{
+		Plan:             tokenConfig.Plan,
+		MaxReqsPerSecond: tokenConfig.MaxReqsPerSecond,
+		Rate:             parseRate(tokenConfig.Rate),
+		Rates:            parseRates(tokenConfig.Rates),
+		Burst:            tokenConfig.Burst,
+		Algorithm:        tokenConfig.Algorithm,
+		Window:           tokenConfig.Window,
+		BlockingDuration: tokenConfig.BlockingDuration,
+		ExpiresAt:        tokenConfig.ExpiresAt,
+		MaxIps:           tokenConfig.MaxIps,
+		MaxIpsWindow:     tokenConfig.MaxIpsWindow}
+}
+
+// getUnknownKeyLimit converts the unknown key limit, required by the limit policy
+func getUnknownKeyLimit(Configs configs.RateLimiterConfigs) rateLimiter.TokenConfig {
+	limit := Configs.UnknownKeyLimit
+	policies := []string{Configs.UnknownKeyPolicy, Configs.ExpiredTokenPolicy}
+	if slices.Contains(policies, string(rateLimiter.LimitKey)) &&
+		limit.Plan == "" && limit.MaxReqsPerSecond == 0 && limit.Rate == "" && len(limit.Rates) == 0 {
+		panic(fmt.Errorf("key policy %q requires unknownKeyLimit", rateLimiter.LimitKey))
+	}
+	return getTokenConfig(limit, Configs.Plans)
+}
+
+// getDistributedLimiter shares the Redis connection of the repository to
+// enforce the limits of every replica in Redis
+func getDistributedLimiter(Configs configs.RateLimiterConfigs, Repository db.RateLimiterRepository) *rateLimiter.RedisLimiter {
+	if !Configs.Distributed {
+		return nil
+	}
+	redisRepository, ok := Repository.(*db.RateLimiterRedisRepository)
+	if !ok {
+		panic(fmt.Errorf("distributed rate limiting requires the redis persistence"))
+	}
+	return rateLimiter.NewRedisLimiter(redisRepository.Client(), redisRepository.Prefix())
+}
+
+func parseKeyPolicy(value string) rateLimiter.KeyPolicy {
+	policy, err := rateLimiter.ParseKeyPolicy(value)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+func parseRate(value string) rateLimiter.Rate {
+	if value == "" {
+		return rateLimiter.Rate{}
+	}
+	rate, err := rateLimiter.ParseRate(value)
+	if err != nil {
+		panic(err)
 	}
+	return rate
+}
+
+func parseRates(values []string) []rateLimiter.Rate {
+	rates := make([]rateLimiter.Rate, 0, len(values))
+	for _, value := range values {
+		rates = append(rates, parseRate(value))
+	}
+	return rates
 }
 
 func (h *RateLimiterMiddleware) Handle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		apiKeyHeader := r.Header.Get("API_KEY")
-		ipAddr, err := getIP(r)
+		ipAddr, err := h.ClientIP.GetIP(r)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
 		log.Println("ipAddr", ipAddr)
-		log.Println("apiKeyHeader", apiKeyHeader)
 
-		allow := h.RateLimiter.Allow(ipAddr, apiKeyHeader)
+		request := rateLimiter.Request{IpAddr: ipAddr}
+		if policy, ok := h.Routes.Match(r); ok {
+			if policy.Exempt {
+				next.ServeHTTP(w, r)
+				return
+			}
+			log.Println("route policy", policy.Name)
+			if policy.Limited {
+				request.Policy = policy.Name
+			}
+			request.Cost = policy.Cost
+		}
+
+		if token := (BearerExtractor{}).Extract(r); h.Jwt != nil && token != "" {
+			claims, err := h.Jwt.Verify(token)
+			if err != nil {
+				log.Println("Invalid JWT", err)
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("invalid token"))
+				return
+			}
+			request.Key = h.Jwt.Subject(claims)
+			request.Authenticated = true
+			request.Plan = h.Jwt.Plan(claims)
+		} else {
+			request.Key = h.ClientKey.Extract(r)
+			log.Println("apiKeyHeader", request.Key)
+		}
+		var decision rateLimiter.Decision
+		if request.MaxWait = h.RateLimiter.Configs.MaxWait; request.MaxWait > 0 {
+			decision = h.RateLimiter.Wait(r.Context(), request)
+		} else {
+			decision = h.RateLimiter.DecideRequest(request)
+		}
+
+		// the request context ends while waiting when the client goes away or a
+		// server deadline expires, and the latter still gets a response
+		if decision.Reason == rateLimiter.Canceled {
+			log.Println("Request canceled while waiting", decision.ClientId)
+			h.setRateLimitHeaders(w, decision)
+			w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(decision.RetryAfter), 10))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(string(decision.Reason)))
+			return
+		}
+
+		if decision.Rejected() {
+			log.Println("Rejected", decision.ClientId, decision.Reason)
+			w.WriteHeader(rejectionStatus(decision.Reason))
+			w.Write([]byte(string(decision.Reason)))
+			return
+		}
+
+		h.setRateLimitHeaders(w, decision)
 
-		if allow {
-			next.ServeHTTP(w, r)
+		if decision.Allowed {
+			h.serveWithCost(next, w, r.WithContext(context.WithValue(r.Context(), decisionKey{}, decision)), request)
 		} else {
+			if !decision.Limit.IsZero() {
+				w.Header().Set("X-RateLimit-Exceeded", decision.Limit.String())
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(decision.RetryAfter), 10))
 			w.WriteHeader(http.StatusTooManyRequests)
 			w.Write([]byte("you have reached the maximum number of requests or actions allowed within a certain time frame"))
 		}
 	})
 }
 
-func getIP(req *http.Request) (string, error) {
-	ip, port, err := net.SplitHostPort(req.RemoteAddr)
-	if err != nil {
-		log.Printf("userip: %q is not IP:port\n", req.RemoteAddr)
-		return "", err
+func (h *RateLimiterMiddleware) setRateLimitHeaders(w http.ResponseWriter, decision rateLimiter.Decision) {
+	resetIn := time.Until(decision.ResetAt)
+	if resetIn < 0 {
+		resetIn = 0
+	}
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(decision.Quota))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(ceilUnix(decision.ResetAt), 10))
+	if decision.KeyIssue != "" {
+		w.Header().Set("X-RateLimit-Key-Issue", string(decision.KeyIssue))
 	}
 
-	userIP := net.ParseIP(ip)
-	if userIP == nil {
-		log.Printf("userip: %q is not IP:port\n", req.RemoteAddr)
-		return "", fmt.Errorf("userip: %q is not IP:port", req.RemoteAddr)
+	if h.IetfHeaders {
+		w.Header().Set("RateLimit", fmt.Sprintf("limit=%d, remaining=%d, reset=%d",
+			decision.Quota, decision.Remaining, ceilSeconds(resetIn)))
+		if !decision.Limit.IsZero() {
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d",
+				int(math.Ceil(decision.Limit.Count)), ceilSeconds(decision.Limit.Period)))
+		}
 	}
+}
 
-	// This will only be defined when site is accessed via non-anonymous proxy
-	// and takes precedence over RemoteAddr
-	// Header.Get is case-insensitive
-	forward := req.Header.Get("X-Forwarded-For")
+// rejectionStatus is 403 for keys used beyond what they allow and 401 for keys
+// that can not be used
+func rejectionStatus(reason rateLimiter.Reason) int {
+	if reason == rateLimiter.TooManyIps {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
 
-	log.Printf("IP: %s\n", ip)
-	log.Printf("Port: %s\n", port)
-	log.Printf("Forwarded for: %s\n", forward)
+func ceilSeconds(duration time.Duration) int64 {
+	return int64(math.Ceil(duration.Seconds()))
+}
 
-	return ip, nil
+func ceilUnix(t time.Time) int64 {
+	return int64(math.Ceil(float64(t.UnixMilli()) / 1000))
 }
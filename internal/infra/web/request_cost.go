@@ -0,0 +1,65 @@
+package web
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	rateLimiter "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/ratelimiter"
+)
+
+// CostHeader lets handlers report the cost of the request in the response
+const CostHeader = "X-RateLimit-Cost"
+
+type costKey struct{}
+
+// requestCost holds the cost a handler reports while serving the request
+type requestCost struct {
+	mu   sync.Mutex
+	cost int
+}
+
+// ReportCost sets how many requests the request being served counts as. The
+// cost beyond what was charged when the request was allowed is charged after
+// the handler returns
+func ReportCost(ctx context.Context, cost int) {
+	if reported, ok := ctx.Value(costKey{}).(*requestCost); ok {
+		reported.mu.Lock()
+		reported.cost = cost
+		reported.mu.Unlock()
+	}
+}
+
+// reportedCost is the cost reported through ReportCost or else the CostHeader
+func reportedCost(w http.ResponseWriter, reported *requestCost) int {
+	reported.mu.Lock()
+	defer reported.mu.Unlock()
+
+	if reported.cost > 0 {
+		return reported.cost
+	}
+	cost, err := strconv.Atoi(w.Header().Get(CostHeader))
+	if err != nil {
+		return 0
+	}
+	return cost
+}
+
+// serveWithCost serves the allowed request, then charges the client the cost
+// the handler reported beyond the one already charged. Clients left without
+// budget are blocked as when exceeding the limit
+func (h *RateLimiterMiddleware) serveWithCost(next http.Handler, w http.ResponseWriter, r *http.Request, request rateLimiter.Request) {
+	reported := &requestCost{}
+	ctx := context.WithValue(r.Context(), costKey{}, reported)
+	next.ServeHTTP(w, r.WithContext(ctx))
+
+	charged := max(request.Cost, 1)
+	if cost := reportedCost(w, reported); cost > charged {
+		request.Cost = cost - charged
+		request.MaxWait = 0
+		decision := h.RateLimiter.DecideRequest(request)
+		log.Println("Charged reported cost", cost, "allowed", decision.Allowed)
+	}
+}
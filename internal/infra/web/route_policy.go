@@ -0,0 +1,81 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	configs "github.com/regismartiny/go-expert-desafio-rate-limiter/configs"
+	rateLimiter "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/ratelimiter"
+)
+
+// RoutePolicy matches requests by chi route pattern and method. Exempt routes
+// are not limited, Limited ones are limited apart under the policy Name and
+// the others by the client limits. Requests count as Cost requests
+type RoutePolicy struct {
+	Name    string
+	Pattern string
+	Methods []string
+	Exempt  bool
+	Limited bool
+	Cost    int
+}
+
+// RoutePolicies are tried in order, the first matching policy applies
+type RoutePolicies []RoutePolicy
+
+func (p RoutePolicies) Match(r *http.Request) (RoutePolicy, bool) {
+	if len(p) == 0 {
+		return RoutePolicy{}, false
+	}
+	routeCtx := matchRoute(r)
+	if routeCtx == nil {
+		return RoutePolicy{}, false
+	}
+
+	pattern := routeCtx.RoutePattern()
+	for _, policy := range p {
+		if policy.Pattern == pattern && (len(policy.Methods) == 0 || slices.Contains(policy.Methods, r.Method)) {
+			return policy, true
+		}
+	}
+	return RoutePolicy{}, false
+}
+
+// getRoutePolicies converts the route configs to the policies matched by the
+// middleware and the limits of each policy, named after its methods and pattern
+func getRoutePolicies(routes []configs.RoutePolicyConfig, plans map[string]configs.TokenConfig) (RoutePolicies, map[string]rateLimiter.TokenConfig) {
+	policies := make(RoutePolicies, 0, len(routes))
+	limits := make(map[string]rateLimiter.TokenConfig, len(routes))
+	for _, route := range routes {
+		methods := make([]string, 0, len(route.Methods))
+		for _, method := range route.Methods {
+			methods = append(methods, strings.ToUpper(method))
+		}
+
+		name := route.Pattern
+		if len(methods) > 0 {
+			name = strings.Join(methods, ",") + " " + route.Pattern
+		}
+
+		limit := route.TokenConfig
+		limited := limit.Plan != "" || limit.MaxReqsPerSecond != 0 || limit.Rate != "" || len(limit.Rates) > 0
+		if !route.Exempt && !limited && route.Cost == 0 {
+			panic(fmt.Errorf("route policy %q has no limit nor cost", name))
+		}
+
+		policies = append(policies, RoutePolicy{
+			Name:    name,
+			Pattern: route.Pattern,
+			Methods: methods,
+			Exempt:  route.Exempt,
+			Limited: limited && !route.Exempt,
+			Cost:    route.Cost,
+		})
+		if limited && !route.Exempt {
+			limits[name] = getTokenConfig(limit, plans)
+		}
+	}
+	return policies, limits
+}
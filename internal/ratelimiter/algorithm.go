@@ -0,0 +1,105 @@
+package ratelimiter
+
+import (
+	"encoding"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	TokenBucket          = "token_bucket"
+	FixedWindow          = "fixed_window"
+	SlidingWindowLog     = "sliding_window_log"
+	SlidingWindowCounter = "sliding_window_counter"
+	GCRA                 = "gcra"
+)
+
+// Limit is the rate a limiter enforces. Window based algorithms count
+// requests over Window, which defaults to the rate period, or to the interval
+// between two requests when the rate is below one request per period.
+// Burst is the bucket capacity of the token bucket and GCRA algorithms,
+// defaulting to the rate count
+type Limit struct {
+	Rate   Rate
+	Burst  int
+	Window time.Duration
+}
+
+func (l Limit) burst() int {
+	if l.Burst <= 0 {
+		return int(math.Ceil(l.Rate.Count))
+	}
+	return l.Burst
+}
+
+// quota is how many requests the limiter of the algorithm accepts at once
+func (l Limit) quota(algorithm Algorithm) int {
+	switch algorithm.(type) {
+	case FixedWindowAlgorithm, SlidingWindowLogAlgorithm, SlidingWindowCounterAlgorithm:
+		return l.reqsPerWindow()
+	default:
+		return l.burst()
+	}
+}
+
+func (l Limit) reqsPerWindow() int {
+	return l.Rate.In(l.window())
+}
+
+func (l Limit) window() time.Duration {
+	if l.Window <= 0 {
+		return max(l.Rate.period(), l.Rate.Every())
+	}
+	return l.Window
+}
+
+// Algorithm creates the limiter that decides if a single client is allowed.
+// Limiters implementing encoding.TextMarshaler and encoding.TextUnmarshaler
+// have their state persisted with the active client
+type Algorithm interface {
+	NewLimiter(limit Limit) Limiter
+}
+
+func marshalLimiterState(limiter Limiter) string {
+	marshaler, ok := limiter.(encoding.TextMarshaler)
+	if !ok {
+		return ""
+	}
+	state, err := marshaler.MarshalText()
+	if err != nil {
+		log.Println("Error marshalling limiter state", err)
+		return ""
+	}
+	return string(state)
+}
+
+func unmarshalLimiterState(limiter Limiter, state string) {
+	unmarshaler, ok := limiter.(encoding.TextUnmarshaler)
+	if !ok || state == "" {
+		return
+	}
+	if err := unmarshaler.UnmarshalText([]byte(state)); err != nil {
+		log.Println("Error unmarshalling limiter state. Starting clean.", err)
+	}
+}
+
+func AlgorithmStrategy(name string) Algorithm {
+	var algorithm Algorithm
+	switch name {
+	case TokenBucket, "":
+		algorithm = TokenBucketAlgorithm{}
+	case FixedWindow:
+		algorithm = FixedWindowAlgorithm{}
+	case SlidingWindowLog:
+		algorithm = SlidingWindowLogAlgorithm{}
+	case SlidingWindowCounter:
+		algorithm = SlidingWindowCounterAlgorithm{}
+	case GCRA:
+		algorithm = GCRAAlgorithm{}
+	default:
+		log.Printf("Unknown rate limiter algorithm %q. Using %s\n", name, TokenBucket)
+		algorithm = TokenBucketAlgorithm{}
+	}
+	return algorithm
+}
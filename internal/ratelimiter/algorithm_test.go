@@ -0,0 +1,265 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AlgorithmTestSuite struct {
+	suite.Suite
+	Now time.Time
+}
+
+func (suite *AlgorithmTestSuite) SetupTest() {
+	suite.Now = time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func TestAlgorithmSuite(t *testing.T) {
+	suite.Run(t, new(AlgorithmTestSuite))
+}
+
+func (suite *AlgorithmTestSuite) TestGivenUnknownAlgorithm_WhenAlgorithmStrategy_ThenShouldUseTokenBucket() {
+
+	suite.IsType(TokenBucketAlgorithm{}, AlgorithmStrategy(""))
+	suite.IsType(TokenBucketAlgorithm{}, AlgorithmStrategy("unknown"))
+	suite.IsType(FixedWindowAlgorithm{}, AlgorithmStrategy(FixedWindow))
+	suite.IsType(SlidingWindowLogAlgorithm{}, AlgorithmStrategy(SlidingWindowLog))
+	suite.IsType(SlidingWindowCounterAlgorithm{}, AlgorithmStrategy(SlidingWindowCounter))
+	suite.IsType(GCRAAlgorithm{}, AlgorithmStrategy(GCRA))
+}
+
+func (suite *AlgorithmTestSuite) TestGivenTokenBucket_WhenLimitReached_ThenShouldRefillOverTime() {
+
+	limiter := TokenBucketAlgorithm{}.NewLimiter(Limit{Rate: PerSecond(2)})
+
+	suite.True(limiter.AllowN(suite.Now, 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now, 1).Allowed)
+	suite.False(limiter.AllowN(suite.Now, 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now.Add(500*time.Millisecond), 1).Allowed)
+}
+
+func (suite *AlgorithmTestSuite) TestGivenFixedWindow_WhenLimitReached_ThenShouldAllowOnlyInNextWindow() {
+
+	limiter := FixedWindowAlgorithm{}.NewLimiter(Limit{Rate: PerSecond(2)})
+
+	suite.True(limiter.AllowN(suite.Now.Add(100*time.Millisecond), 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now.Add(200*time.Millisecond), 1).Allowed)
+	suite.False(limiter.AllowN(suite.Now.Add(900*time.Millisecond), 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now.Add(1000*time.Millisecond), 1).Allowed)
+}
+
+func (suite *AlgorithmTestSuite) TestGivenSlidingWindowLog_WhenBurstStraddlesWindowBoundary_ThenShouldLimitAnyRollingWindow() {
+
+	limiter := SlidingWindowLogAlgorithm{}.NewLimiter(Limit{Rate: PerSecond(2), Window: 2 * time.Second})
+
+	suite.True(limiter.AllowN(suite.Now.Add(1500*time.Millisecond), 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now.Add(1600*time.Millisecond), 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now.Add(1700*time.Millisecond), 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now.Add(1800*time.Millisecond), 1).Allowed)
+	suite.False(limiter.AllowN(suite.Now.Add(2100*time.Millisecond), 1).Allowed)
+	suite.False(limiter.AllowN(suite.Now.Add(3499*time.Millisecond), 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now.Add(3500*time.Millisecond), 1).Allowed)
+}
+
+func (suite *AlgorithmTestSuite) TestGivenSlidingWindowCounter_WhenPreviousWindowFull_ThenShouldWeightPreviousCount() {
+
+	limiter := SlidingWindowCounterAlgorithm{}.NewLimiter(Limit{Rate: PerSecond(4)})
+
+	for i := 0; i < 4; i++ {
+		suite.True(limiter.AllowN(suite.Now.Add(900*time.Millisecond), 1).Allowed)
+	}
+	suite.False(limiter.AllowN(suite.Now.Add(950*time.Millisecond), 1).Allowed)
+
+	// 75% of the previous window still overlaps: 3 estimated requests
+	suite.True(limiter.AllowN(suite.Now.Add(1250*time.Millisecond), 1).Allowed)
+	suite.False(limiter.AllowN(suite.Now.Add(1250*time.Millisecond), 1).Allowed)
+
+	// previous window no longer adjacent
+	suite.True(limiter.AllowN(suite.Now.Add(3000*time.Millisecond), 1).Allowed)
+}
+
+func (suite *AlgorithmTestSuite) TestGivenGCRA_WhenLimitReached_ThenShouldAllowAfterEmissionInterval() {
+
+	limiter := GCRAAlgorithm{}.NewLimiter(Limit{Rate: PerSecond(2)})
+
+	suite.True(limiter.AllowN(suite.Now, 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now, 1).Allowed)
+	suite.False(limiter.AllowN(suite.Now, 1).Allowed)
+	suite.False(limiter.AllowN(suite.Now.Add(499*time.Millisecond), 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now.Add(500*time.Millisecond), 1).Allowed)
+}
+
+func (suite *AlgorithmTestSuite) TestGivenGCRAState_WhenRestored_ThenShouldKeepTheoreticalArrivalTime() {
+
+	limiter := GCRAAlgorithm{}.NewLimiter(Limit{Rate: PerSecond(1)})
+	suite.True(limiter.AllowN(suite.Now, 1).Allowed)
+
+	state := marshalLimiterState(limiter)
+	suite.NotEmpty(state)
+
+	restored := GCRAAlgorithm{}.NewLimiter(Limit{Rate: PerSecond(1)})
+	unmarshalLimiterState(restored, state)
+
+	suite.False(restored.AllowN(suite.Now.Add(500*time.Millisecond), 1).Allowed)
+	suite.True(restored.AllowN(suite.Now.Add(1*time.Second), 1).Allowed)
+}
+
+func (suite *AlgorithmTestSuite) TestGivenBurstGreaterThanRate_WhenBatchSent_ThenShouldAllowWholeBatch() {
+
+	for _, algorithm := range []Algorithm{TokenBucketAlgorithm{}, GCRAAlgorithm{}} {
+		limiter := algorithm.NewLimiter(Limit{Rate: PerSecond(1), Burst: 5})
+
+		for i := 0; i < 5; i++ {
+			suite.True(limiter.AllowN(suite.Now, 1).Allowed)
+		}
+		suite.False(limiter.AllowN(suite.Now, 1).Allowed)
+		suite.True(limiter.AllowN(suite.Now.Add(1*time.Second), 1).Allowed)
+	}
+}
+
+func (suite *AlgorithmTestSuite) TestGivenPerMinuteRate_WhenLimitReached_ThenAllAlgorithmsShouldAllowOnlyAfterPeriod() {
+
+	limit := Limit{Rate: Rate{Count: 2, Period: time.Minute}}
+
+	for _, name := range []string{TokenBucket, FixedWindow, SlidingWindowLog, SlidingWindowCounter, GCRA} {
+		limiter := AlgorithmStrategy(name).NewLimiter(limit)
+
+		suite.True(limiter.AllowN(suite.Now, 1).Allowed, name)
+		suite.True(limiter.AllowN(suite.Now, 1).Allowed, name)
+		suite.False(limiter.AllowN(suite.Now.Add(10*time.Second), 1).Allowed, name)
+		suite.True(limiter.AllowN(suite.Now.Add(2*time.Minute), 1).Allowed, name)
+	}
+}
+
+func (suite *AlgorithmTestSuite) TestGivenFractionalRate_WhenLimitReached_ThenShouldAllowOneRequestEveryInterval() {
+
+	for _, name := range []string{TokenBucket, FixedWindow, SlidingWindowLog, SlidingWindowCounter, GCRA} {
+		suite.Run(name, func() {
+			limiter := AlgorithmStrategy(name).NewLimiter(Limit{Rate: Rate{Count: 0.5, Period: time.Second}})
+
+			result := limiter.AllowN(suite.Now, 1)
+			suite.True(result.Allowed)
+			suite.Equal(1, result.Quota)
+
+			result = limiter.AllowN(suite.Now.Add(1*time.Second), 1)
+			suite.False(result.Allowed)
+			suite.Positive(result.RetryAfter)
+
+			suite.True(limiter.AllowN(suite.Now.Add(1*time.Second+result.RetryAfter), 1).Allowed)
+		})
+	}
+}
+
+func (suite *AlgorithmTestSuite) TestGivenSeveralWindows_WhenAnyWindowExhausted_ThenShouldDenyReportingTheWindow() {
+
+	perSecond := Rate{Count: 2, Period: time.Second}
+	perMinute := Rate{Count: 3, Period: time.Minute}
+	limiter := newLimiter(GCRAAlgorithm{}, []Limit{{Rate: perMinute}, {Rate: perSecond}})
+
+	suite.True(limiter.AllowN(suite.Now, 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now, 1).Allowed)
+
+	result := limiter.AllowN(suite.Now, 1)
+	suite.False(result.Allowed)
+	suite.Equal(perSecond, result.Limit)
+
+	suite.True(limiter.AllowN(suite.Now.Add(1*time.Second), 1).Allowed)
+
+	result = limiter.AllowN(suite.Now.Add(2*time.Second), 1)
+	suite.False(result.Allowed)
+	suite.Equal(perMinute, result.Limit)
+}
+
+func (suite *AlgorithmTestSuite) TestGivenSeveralWindows_WhenDeniedByLongerWindow_ThenShouldNotConsumeTheShorterOnes() {
+
+	perSecond := Rate{Count: 5, Period: time.Second}
+	perMinute := Rate{Count: 1, Period: time.Minute}
+	for _, name := range []string{TokenBucket, FixedWindow, SlidingWindowLog, SlidingWindowCounter, GCRA} {
+		suite.Run(name, func() {
+			limiter := newLimiter(AlgorithmStrategy(name), []Limit{{Rate: perSecond}, {Rate: perMinute}})
+
+			suite.True(limiter.AllowN(suite.Now, 1).Allowed)
+			for i := 0; i < 4; i++ {
+				result := limiter.AllowN(suite.Now, 1)
+				suite.False(result.Allowed)
+				suite.Equal(perMinute, result.Limit)
+			}
+
+			shortest := limiter.(*multiLimiter).limiters[0]
+			suite.True(shortest.(checker).check(suite.Now, 4).Allowed)
+			suite.False(shortest.(checker).check(suite.Now, 5).Allowed)
+		})
+	}
+}
+
+func (suite *AlgorithmTestSuite) TestGivenSeveralWindows_WhenStateRestored_ThenShouldRestoreEveryWindow() {
+
+	limits := []Limit{{Rate: Rate{Count: 1, Period: time.Second}}, {Rate: Rate{Count: 2, Period: time.Minute}}}
+	limiter := newLimiter(GCRAAlgorithm{}, limits)
+	suite.True(limiter.AllowN(suite.Now, 1).Allowed)
+	suite.True(limiter.AllowN(suite.Now.Add(1*time.Second), 1).Allowed)
+
+	restored := newLimiter(GCRAAlgorithm{}, limits)
+	unmarshalLimiterState(restored, marshalLimiterState(limiter))
+
+	suite.False(restored.AllowN(suite.Now.Add(2*time.Second), 1).Allowed)
+}
+
+func (suite *AlgorithmTestSuite) TestGivenAnyAlgorithm_WhenDenied_ThenShouldReportRemainingAndAllowAfterRetryAfter() {
+
+	limit := Limit{Rate: Rate{Count: 3, Period: time.Second}}
+
+	for _, name := range []string{TokenBucket, FixedWindow, SlidingWindowLog, SlidingWindowCounter, GCRA} {
+		limiter := AlgorithmStrategy(name).NewLimiter(limit)
+		now := suite.Now.Add(250 * time.Millisecond)
+
+		for remaining := 2; remaining >= 0; remaining-- {
+			result := limiter.AllowN(now, 1)
+			suite.True(result.Allowed, name)
+			suite.Equal(limit.Rate, result.Limit, name)
+			suite.Equal(3, result.Quota, name)
+			suite.Equal(remaining, result.Remaining, name)
+			suite.False(result.ResetAt.Before(now), name)
+		}
+
+		result := limiter.AllowN(now, 1)
+		suite.False(result.Allowed, name)
+		suite.Equal(0, result.Remaining, name)
+		suite.Positive(result.RetryAfter, name)
+
+		suite.False(limiter.AllowN(now.Add(result.RetryAfter-time.Millisecond), 1).Allowed, name)
+		suite.True(limiter.AllowN(now.Add(result.RetryAfter), 1).Allowed, name)
+	}
+}
+
+func (suite *AlgorithmTestSuite) TestGivenAnyAlgorithm_WhenRequestCostsSeveralRequests_ThenShouldConsumeItsCost() {
+
+	limit := Limit{Rate: Rate{Count: 5, Period: time.Second}}
+
+	for _, name := range []string{TokenBucket, FixedWindow, SlidingWindowLog, SlidingWindowCounter, GCRA} {
+		limiter := AlgorithmStrategy(name).NewLimiter(limit)
+		now := suite.Now.Add(250 * time.Millisecond)
+
+		result := limiter.AllowN(now, 3)
+		suite.True(result.Allowed, name)
+		suite.Equal(2, result.Remaining, name)
+
+		result = limiter.AllowN(now, 3)
+		suite.False(result.Allowed, name)
+		suite.Positive(result.RetryAfter, name)
+
+		suite.False(limiter.AllowN(now.Add(result.RetryAfter-time.Millisecond), 3).Allowed, name)
+		suite.True(limiter.AllowN(now.Add(result.RetryAfter), 3).Allowed, name)
+
+		fresh := AlgorithmStrategy(name).NewLimiter(limit)
+		result = fresh.AllowN(now, 6)
+		suite.False(result.Allowed, name)
+		suite.Zero(result.RetryAfter, name)
+
+		result = limiter.AllowN(now, 6)
+		suite.False(result.Allowed, name)
+		suite.Zero(result.RetryAfter, name)
+	}
+}
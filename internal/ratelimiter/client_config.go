@@ -0,0 +1,79 @@
+package ratelimiter
+
+import (
+	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+)
+
+// clientConfig resolves the settings applied to a client: the settings of its
+// route policy, the IP settings, the unknown key limit or the token settings,
+// merged with their plan. Algorithm, Window and BlockingDuration default to the
+// global settings
+func (r *RateLimiter) clientConfig(client entity.ActiveClient) TokenConfig {
+	var config TokenConfig
+	switch {
+	case client.Policy != "":
+		config = r.Configs.Policies[client.Policy]
+	case client.ClientType == entity.Ip:
+		return TokenConfig{
+			MaxReqsPerSecond: r.Configs.IpMaxReqsPerSecond,
+			Rate:             r.Configs.IpRate,
+			Rates:            r.Configs.IpRates,
+			Burst:            r.Configs.IpBurst,
+			Algorithm:        r.Configs.Algorithm,
+			Window:           r.Configs.Window,
+			BlockingDuration: r.Configs.BlockingDuration,
+		}
+	case client.ClientType == entity.UnknownKey:
+		config = r.Configs.UnknownKeyLimit
+	case client.Plan != "":
+		config = TokenConfig{Plan: client.Plan}
+	default:
+		config = r.Configs.TokenConfigs[client.ClientId]
+	}
+
+	if config.Plan != "" {
+		config = config.withPlan(r.Configs.Plans[config.Plan])
+	}
+
+	if config.Algorithm == "" {
+		config.Algorithm = r.Configs.Algorithm
+	}
+	if config.Window == 0 {
+		config.Window = r.Configs.Window
+	}
+	if config.BlockingDuration == 0 {
+		config.BlockingDuration = r.Configs.BlockingDuration
+	}
+	return config
+}
+
+// withPlan returns the plan settings overridden by the ones set on the token.
+// Any of MaxReqsPerSecond, Rate and Rates replaces all the plan rates
+func (c TokenConfig) withPlan(plan TokenConfig) TokenConfig {
+	plan.Plan = c.Plan
+	plan.ExpiresAt = c.ExpiresAt
+	if c.MaxReqsPerSecond != 0 || !c.Rate.IsZero() || len(c.Rates) > 0 {
+		plan.MaxReqsPerSecond = c.MaxReqsPerSecond
+		plan.Rate = c.Rate
+		plan.Rates = c.Rates
+	}
+	if c.Burst != 0 {
+		plan.Burst = c.Burst
+	}
+	if c.Algorithm != "" {
+		plan.Algorithm = c.Algorithm
+	}
+	if c.Window != 0 {
+		plan.Window = c.Window
+	}
+	if c.BlockingDuration != 0 {
+		plan.BlockingDuration = c.BlockingDuration
+	}
+	if c.MaxIps != 0 {
+		plan.MaxIps = c.MaxIps
+	}
+	if c.MaxIpsWindow != 0 {
+		plan.MaxIpsWindow = c.MaxIpsWindow
+	}
+	return plan
+}
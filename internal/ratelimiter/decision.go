@@ -0,0 +1,100 @@
+package ratelimiter
+
+import (
+	"math"
+	"time"
+
+	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+)
+
+// Reason explains why a request was denied or why its key was not used
+type Reason string
+
+const (
+	RateExceeded Reason = "rate_exceeded"
+	StillBlocked Reason = "blocked"
+	TokenExpired Reason = "token_expired"
+	UnknownKey   Reason = "unknown_key"
+	TooManyIps   Reason = "too_many_ips"
+	Delayed      Reason = "delayed"
+	QueueFull    Reason = "queue_full"
+	Canceled     Reason = "canceled"
+)
+
+// Decision is the outcome of a rate limiter check. Limit is the rate that
+// decided the request, the window that tripped when it is denied, and Quota,
+// Remaining, ResetAt and RetryAfter describe the client state after it.
+// KeyIssue tells why the key sent with the request was not used
+type Decision struct {
+	Allowed    bool
+	Reason     Reason
+	ClientId   string
+	ClientType entity.ClientType
+	Limit      Rate
+	Quota      int
+	Remaining  int
+	ResetAt    time.Time
+	RetryAfter time.Duration
+	KeyIssue   Reason
+}
+
+func newDecision(client entity.ActiveClient, result Result) Decision {
+	decision := Decision{
+		Allowed:    result.Allowed,
+		ClientId:   client.ClientId,
+		ClientType: client.ClientType,
+		Limit:      result.Limit,
+		Quota:      result.Quota,
+		Remaining:  result.Remaining,
+		ResetAt:    result.ResetAt,
+		RetryAfter: result.RetryAfter,
+	}
+	if !result.Allowed {
+		decision.Reason = RateExceeded
+	}
+	return decision
+}
+
+// newRejectedDecision denies a request before any limit is applied
+func newRejectedDecision(id string, clientType entity.ClientType, reason Reason) Decision {
+	return Decision{
+		Allowed:    false,
+		Reason:     reason,
+		ClientId:   id,
+		ClientType: clientType,
+		KeyIssue:   reason,
+	}
+}
+
+// Rejected tells if the request was denied before any limit was applied
+func (d Decision) Rejected() bool {
+	return !d.Allowed && (d.Reason == UnknownKey || d.Reason == TokenExpired || d.Reason == TooManyIps)
+}
+
+// newDelayedDecision describes a denied request that fits after RetryAfter
+func newDelayedDecision(client entity.ActiveClient, result Result) Decision {
+	decision := newDecision(client, result)
+	decision.Reason = Delayed
+	return decision
+}
+
+// newBlockedDecision describes a client blocked until BlockedUntil, either by
+// this request or by a previous one
+func newBlockedDecision(client entity.ActiveClient, reason Reason, result Result, now time.Time) Decision {
+	decision := newDecision(client, result)
+	decision.Allowed = false
+	decision.Reason = reason
+	decision.Remaining = 0
+
+	if decision.Limit.IsZero() {
+		decision.Limit, _ = ParseRate(client.BlockedBy)
+		decision.Quota = int(math.Ceil(decision.Limit.Count))
+	}
+	if client.BlockedUntil.After(decision.ResetAt) {
+		decision.ResetAt = client.BlockedUntil
+	}
+	if blockedFor := client.BlockedUntil.Sub(now); blockedFor > decision.RetryAfter {
+		decision.RetryAfter = blockedFor
+	}
+	return decision
+}
@@ -0,0 +1,68 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// FixedWindowAlgorithm counts requests inside windows aligned to the clock
+type FixedWindowAlgorithm struct{}
+
+func (a FixedWindowAlgorithm) NewLimiter(limit Limit) Limiter {
+	return &fixedWindowLimiter{
+		rate:   limit.Rate,
+		max:    limit.reqsPerWindow(),
+		window: limit.window(),
+	}
+}
+
+type fixedWindowLimiter struct {
+	mu          sync.Mutex
+	rate        Rate
+	max         int
+	window      time.Duration
+	windowStart time.Time
+	count       int
+}
+
+func (l *fixedWindowLimiter) AllowN(now time.Time, n int) Result {
+	return l.apply(now, n, true)
+}
+
+func (l *fixedWindowLimiter) check(now time.Time, n int) Result {
+	return l.apply(now, n, false)
+}
+
+func (l *fixedWindowLimiter) apply(now time.Time, n int, consume bool) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	windowStart := now.Truncate(l.window)
+	if !windowStart.Equal(l.windowStart) {
+		l.windowStart = windowStart
+		l.count = 0
+	}
+
+	windowEnd := l.windowStart.Add(l.window)
+
+	if l.count+n > l.max {
+		result := Result{Allowed: false, Limit: l.rate, Quota: l.max, ResetAt: windowEnd}
+		// a request costing more than the window allows never fits
+		if n <= l.max {
+			result.RetryAfter = windowEnd.Sub(now)
+		}
+		return result
+	}
+
+	remaining := l.max - l.count - n
+	if consume {
+		l.count += n
+	}
+	return Result{
+		Allowed:   true,
+		Limit:     l.rate,
+		Quota:     l.max,
+		Remaining: remaining,
+		ResetAt:   windowEnd,
+	}
+}
@@ -0,0 +1,103 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+// GCRAAlgorithm implements the Generic Cell Rate Algorithm. The whole state of
+// a client is its theoretical arrival time (TAT), which makes it cheap to persist
+type GCRAAlgorithm struct{}
+
+func (a GCRAAlgorithm) NewLimiter(limit Limit) Limiter {
+	limiter := &gcraLimiter{rate: limit.Rate, emissionInterval: limit.Rate.Every()}
+	if limiter.emissionInterval > 0 && limit.burst() > 0 {
+		limiter.burstTolerance = limiter.emissionInterval * time.Duration(limit.burst()-1)
+	} else {
+		limiter.emissionInterval = 0
+	}
+	return limiter
+}
+
+type gcraLimiter struct {
+	mu               sync.Mutex
+	rate             Rate
+	emissionInterval time.Duration
+	burstTolerance   time.Duration
+	tat              time.Time
+}
+
+func (l *gcraLimiter) AllowN(now time.Time, n int) Result {
+	return l.apply(now, n, true)
+}
+
+func (l *gcraLimiter) check(now time.Time, n int) Result {
+	return l.apply(now, n, false)
+}
+
+func (l *gcraLimiter) apply(now time.Time, n int, consume bool) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.emissionInterval == 0 {
+		return Result{Allowed: false, Limit: l.rate, ResetAt: now}
+	}
+
+	tat := l.tat
+	if tat.Before(now) {
+		tat = now
+	}
+
+	// a request costing n takes the cells of n requests
+	increment := l.emissionInterval * time.Duration(n)
+	if tat.Add(increment-l.emissionInterval).Sub(now) > l.burstTolerance {
+		result := Result{
+			Allowed: false,
+			Limit:   l.rate,
+			Quota:   l.quota(),
+			ResetAt: tat,
+		}
+		if increment-l.emissionInterval <= l.burstTolerance {
+			result.RetryAfter = tat.Add(increment-l.emissionInterval).Sub(now) - l.burstTolerance
+		}
+		return result
+	}
+
+	tat = tat.Add(increment)
+	if consume {
+		l.tat = tat
+	}
+	return Result{
+		Allowed:   true,
+		Limit:     l.rate,
+		Quota:     l.quota(),
+		Remaining: int((l.burstTolerance-tat.Sub(now))/l.emissionInterval) + 1,
+		ResetAt:   tat,
+	}
+}
+
+func (l *gcraLimiter) quota() int {
+	return int(l.burstTolerance/l.emissionInterval) + 1
+}
+
+// MarshalText exposes the TAT so it can be stored with the active client
+func (l *gcraLimiter) MarshalText() ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.tat.IsZero() {
+		return []byte{}, nil
+	}
+	return l.tat.MarshalText()
+}
+
+func (l *gcraLimiter) UnmarshalText(text []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(text) == 0 {
+		l.tat = time.Time{}
+		return nil
+	}
+	return l.tat.UnmarshalText(text)
+}
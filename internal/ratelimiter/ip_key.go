@@ -0,0 +1,31 @@
+package ratelimiter
+
+import (
+	"net/netip"
+)
+
+// ipKey normalizes the client IP used as the active client id. IPv4-mapped IPv6
+// addresses become IPv4 and, when a prefix is configured, addresses are
+// aggregated into their network, so a client rotating addresses inside its
+// IPv6 /64 still shares a single limit
+func (r *RateLimiter) ipKey(ipAddr string) string {
+	addr, err := netip.ParseAddr(ipAddr)
+	if err != nil {
+		return ipAddr
+	}
+	addr = addr.Unmap().WithZone("")
+
+	bits := r.Configs.IpV6Prefix
+	if addr.Is4() {
+		bits = r.Configs.IpV4Prefix
+	}
+	if bits <= 0 || bits >= addr.BitLen() {
+		return addr.String()
+	}
+
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.String()
+}
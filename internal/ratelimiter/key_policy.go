@@ -0,0 +1,58 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+
+	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+)
+
+// KeyPolicy tells how requests with a key that can not be used are handled
+type KeyPolicy string
+
+const (
+	// FallbackToIp limits the request by the client IP
+	FallbackToIp KeyPolicy = "ip"
+	// RejectKey denies the request without consuming any limit
+	RejectKey KeyPolicy = "reject"
+	// LimitKey limits the request by the client IP under the UnknownKeyLimit
+	LimitKey KeyPolicy = "limit"
+)
+
+// unknownKeyPrefix keeps the unknown key limit of an IP apart from its IP limit
+const unknownKeyPrefix = "unknown_key:"
+
+// ParseKeyPolicy parses a key policy, empty defaults to FallbackToIp
+func ParseKeyPolicy(value string) (KeyPolicy, error) {
+	switch KeyPolicy(value) {
+	case "", FallbackToIp:
+		return FallbackToIp, nil
+	case RejectKey:
+		return RejectKey, nil
+	case LimitKey:
+		return LimitKey, nil
+	default:
+		return "", fmt.Errorf("unknown key policy %q", value)
+	}
+}
+
+// decideInvalidKey applies the policy of a request whose key can not be used,
+// recording the reason in the KeyIssue of the decision
+func (r *RateLimiter) decideInvalidKey(request Request, reason Reason, policy KeyPolicy) Decision {
+	var decision Decision
+	switch policy {
+	case RejectKey:
+		return newRejectedDecision(request.Key, entity.Token, reason)
+	case LimitKey:
+		decision = r.verifyClientAllowed(request.client(unknownKeyPrefix+r.ipKey(request.IpAddr), entity.UnknownKey), request)
+	default:
+		decision = r.decideIp(request)
+	}
+	decision.KeyIssue = reason
+	return decision
+}
+
+// expired tells if the token has an expiry and it has passed
+func (c TokenConfig) expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt)
+}
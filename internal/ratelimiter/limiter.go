@@ -0,0 +1,144 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limiter decides whether a client request costing n requests is allowed at
+// a given time. Denied requests consume nothing
+type Limiter interface {
+	AllowN(now time.Time, n int) Result
+}
+
+// Result is the outcome of a limiter check. Limit is the rate of the window
+// that decided the request and Quota is how many requests it accepts at once.
+// ResetAt is when the quota is fully available again and RetryAfter, set only
+// when the request is denied, is how long until the next request fits
+type Result struct {
+	Allowed    bool
+	Limit      Rate
+	Quota      int
+	Remaining  int
+	ResetAt    time.Time
+	RetryAfter time.Duration
+	// BlockedUntil is set when the limiter keeps the block of the client and
+	// denied the request because the client is blocked, by this or another replica
+	BlockedUntil time.Time
+}
+
+// checker is implemented by the limiters able to tell whether a request would
+// be allowed without consuming it
+type checker interface {
+	check(now time.Time, n int) Result
+}
+
+// blocker is implemented by the limiters keeping the block of the client, so
+// it is shared by every replica
+type blocker interface {
+	block(by Rate, duration time.Duration)
+}
+
+// multiLimiter enforces several windows at once, denying the request when any
+// of them is exhausted. Every window is checked, from the shortest to the
+// longest, before any of them is consumed
+type multiLimiter struct {
+	mu       sync.Mutex
+	limiters []Limiter
+}
+
+func newLimiter(algorithm Algorithm, limits []Limit) Limiter {
+	if len(limits) == 1 {
+		return algorithm.NewLimiter(limits[0])
+	}
+
+	sortLimits(limits)
+
+	limiter := &multiLimiter{limiters: make([]Limiter, 0, len(limits))}
+	for _, limit := range limits {
+		limiter.limiters = append(limiter.limiters, algorithm.NewLimiter(limit))
+	}
+	return limiter
+}
+
+// sortLimits sorts the limits from the shortest window to the longest
+func sortLimits(limits []Limit) {
+	sort.SliceStable(limits, func(i, j int) bool {
+		return limits[i].window() < limits[j].window()
+	})
+}
+
+// AllowN reports the window that denied the request or, when allowed, the
+// window with fewest remaining requests
+func (l *multiLimiter) AllowN(now time.Time, n int) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if result := l.checkWindows(now, n); !result.Allowed {
+		return result
+	}
+
+	var result Result
+	resetAt := now
+	for i, limiter := range l.limiters {
+		windowResult := limiter.AllowN(now, n)
+		if !windowResult.Allowed {
+			return windowResult
+		}
+		if i == 0 || windowResult.Remaining < result.Remaining {
+			result = windowResult
+		}
+		if windowResult.ResetAt.After(resetAt) {
+			resetAt = windowResult.ResetAt
+		}
+	}
+	result.ResetAt = resetAt
+	return result
+}
+
+func (l *multiLimiter) check(now time.Time, n int) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.checkWindows(now, n)
+}
+
+// checkWindows returns the first window denying the request
+func (l *multiLimiter) checkWindows(now time.Time, n int) Result {
+	for _, limiter := range l.limiters {
+		if checker, ok := limiter.(checker); ok {
+			if result := checker.check(now, n); !result.Allowed {
+				return result
+			}
+		}
+	}
+	return Result{Allowed: true}
+}
+
+func (l *multiLimiter) MarshalText() ([]byte, error) {
+	states := make([]string, len(l.limiters))
+	empty := true
+	for i, limiter := range l.limiters {
+		states[i] = marshalLimiterState(limiter)
+		empty = empty && states[i] == ""
+	}
+	if empty {
+		return []byte{}, nil
+	}
+	return json.Marshal(states)
+}
+
+func (l *multiLimiter) UnmarshalText(text []byte) error {
+	var states []string
+	if err := json.Unmarshal(text, &states); err != nil {
+		return err
+	}
+	for i, state := range states {
+		if i < len(l.limiters) {
+			unmarshalLimiterState(l.limiters[i], state)
+		}
+	}
+	return nil
+}
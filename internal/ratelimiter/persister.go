@@ -0,0 +1,187 @@
+package ratelimiter
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+	db "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/infra/database"
+)
+
+const (
+	defaultPersistInterval  = 1 * time.Second
+	defaultPersistBatchSize = 100
+	maxPersistBackoff       = 30 * time.Second
+	shutdownFlushTimeout    = 5 * time.Second
+)
+
+// persister writes the active clients to the repository off the request path.
+// Changes of the same client are coalesced until the next flush, which happens
+// every interval or as soon as batchSize clients are pending. Failed flushes are
+// retried with exponential backoff, keeping the changes made in the meantime
+type persister struct {
+	repository db.RateLimiterRepository
+	interval   time.Duration
+	batchSize  int
+
+	mu      sync.Mutex
+	pending map[string]pendingClient
+
+	flushMu   sync.Mutex
+	full      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// pendingClient is the last change of a client not persisted yet
+type pendingClient struct {
+	client  entity.ActiveClient
+	deleted bool
+}
+
+func newPersister(repository db.RateLimiterRepository, interval time.Duration, batchSize int) *persister {
+	if interval <= 0 {
+		interval = defaultPersistInterval
+	}
+	if batchSize <= 0 {
+		batchSize = defaultPersistBatchSize
+	}
+	return &persister{
+		repository: repository,
+		interval:   interval,
+		batchSize:  batchSize,
+		pending:    make(map[string]pendingClient),
+		full:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (p *persister) save(client entity.ActiveClient) {
+	p.mark(client.ClientId, pendingClient{client: client})
+}
+
+func (p *persister) delete(id string) {
+	p.mark(id, pendingClient{client: entity.ActiveClient{ClientId: id}, deleted: true})
+}
+
+// mark replaces the pending change of the client, waking the flusher up when
+// the batch is full. It never waits for the repository
+func (p *persister) mark(id string, change pendingClient) {
+	p.mu.Lock()
+	p.pending[id] = change
+	full := len(p.pending) >= p.batchSize
+	p.mu.Unlock()
+
+	if full {
+		select {
+		case p.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run flushes the pending changes until ctx is done, flushing them one last
+// time, or until the persister is closed
+func (p *persister) run(ctx context.Context) {
+	defer close(p.done)
+
+	wait := p.interval
+	backoff := time.Duration(0)
+	for {
+		// a full batch does not cut a backoff short
+		full := p.full
+		if backoff > 0 {
+			full = nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("Stopped active clients persister...")
+			flushCtx, cancel := context.WithTimeout(context.Background(), shutdownFlushTimeout)
+			if err := p.flush(flushCtx); err != nil {
+				log.Println("Error persisting active clients on shutdown", err)
+			}
+			cancel()
+			return
+		case <-p.stop:
+			timer.Stop()
+			return
+		case <-full:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		if err := p.flush(ctx); err != nil {
+			backoff = min(max(2*backoff, p.interval), maxPersistBackoff)
+			wait = backoff
+			log.Printf("Error persisting active clients, retrying in %s: %v\n", backoff, err)
+			continue
+		}
+		backoff = 0
+		wait = p.interval
+	}
+}
+
+// flush writes the pending changes to the repository. On failure they are
+// pending again, unless the client changed since
+func (p *persister) flush(ctx context.Context) error {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	batch := p.pending
+	p.pending = make(map[string]pendingClient)
+	p.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	clients := make([]entity.ActiveClient, 0, len(batch))
+	deleted := make([]string, 0)
+	for id, change := range batch {
+		if change.deleted {
+			deleted = append(deleted, id)
+		} else {
+			clients = append(clients, change.client)
+		}
+	}
+
+	var err error
+	if len(clients) > 0 {
+		err = p.repository.PutMany(ctx, clients)
+	}
+	if err == nil && len(deleted) > 0 {
+		err = p.repository.DeleteMany(ctx, deleted)
+	}
+	if err != nil {
+		p.mu.Lock()
+		for id, change := range batch {
+			if _, changed := p.pending[id]; !changed {
+				p.pending[id] = change
+			}
+		}
+		p.mu.Unlock()
+		return err
+	}
+
+	log.Printf("%d active clients persisted\n", len(batch))
+	return nil
+}
+
+// close stops the flusher and flushes the pending changes
+func (p *persister) close(ctx context.Context) error {
+	p.closeOnce.Do(func() { close(p.stop) })
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.flush(ctx)
+}
@@ -0,0 +1,173 @@
+package ratelimiter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+	db "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/infra/database"
+	"github.com/stretchr/testify/suite"
+)
+
+// flakyRepository fails the writes while fail is set, counting them
+type flakyRepository struct {
+	db.RateLimiterRepository
+	mu     sync.Mutex
+	fail   bool
+	writes int
+}
+
+func (r *flakyRepository) setFail(fail bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fail = fail
+}
+
+func (r *flakyRepository) write() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writes++
+	if r.fail {
+		return errors.New("repository unavailable")
+	}
+	return nil
+}
+
+func (r *flakyRepository) PutMany(ctx context.Context, clients []entity.ActiveClient) error {
+	if err := r.write(); err != nil {
+		return err
+	}
+	return r.RateLimiterRepository.PutMany(ctx, clients)
+}
+
+func (r *flakyRepository) DeleteMany(ctx context.Context, ids []string) error {
+	if err := r.write(); err != nil {
+		return err
+	}
+	return r.RateLimiterRepository.DeleteMany(ctx, ids)
+}
+
+type PersisterTestSuite struct {
+	suite.Suite
+	Ctx        context.Context
+	Cancel     context.CancelFunc
+	Db         *sql.DB
+	Repository *flakyRepository
+}
+
+func (suite *PersisterTestSuite) SetupTest() {
+	suite.Ctx, suite.Cancel = context.WithCancel(context.Background())
+	client, err := sql.Open("sqlite3", ":memory:")
+	suite.NoError(err)
+	client.SetMaxOpenConns(1)
+	suite.NoError(db.MigrateSQLite(suite.Ctx, client))
+	suite.Db = client
+	suite.Repository = &flakyRepository{RateLimiterRepository: db.NewRateLimiterSQLiteRepository(suite.Db)}
+}
+
+func (suite *PersisterTestSuite) TearDownTest() {
+	suite.Cancel()
+	suite.Db.Close()
+}
+
+func TestPersisterSuite(t *testing.T) {
+	suite.Run(t, new(PersisterTestSuite))
+}
+
+func (suite *PersisterTestSuite) activeClients() map[string]entity.ActiveClient {
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
+	suite.NoError(err)
+	return activeClients
+}
+
+func (suite *PersisterTestSuite) TestGivenSeveralChangesOfAClient_WhenFlush_ThenShouldWriteOnlyTheLastOne() {
+
+	persister := newPersister(suite.Repository, time.Hour, 100)
+
+	persister.save(entity.ActiveClient{ClientId: "127.0.0.1", ClientType: entity.Ip})
+	persister.save(entity.ActiveClient{ClientId: "127.0.0.1", ClientType: entity.Ip, Blocked: true, BlockedBy: "1/s"})
+	persister.save(entity.ActiveClient{ClientId: "abc123", ClientType: entity.Token})
+	persister.delete("abc123")
+
+	suite.NoError(persister.flush(suite.Ctx))
+
+	activeClients := suite.activeClients()
+	suite.Equal(1, len(activeClients))
+	suite.True(activeClients["127.0.0.1"].Blocked)
+	suite.Equal("1/s", activeClients["127.0.0.1"].BlockedBy)
+	suite.Equal(2, suite.Repository.writes)
+
+	suite.NoError(persister.flush(suite.Ctx))
+	suite.Equal(2, suite.Repository.writes)
+}
+
+func (suite *PersisterTestSuite) TestGivenFailingRepository_WhenFlush_ThenShouldKeepTheNewestChangesPending() {
+
+	persister := newPersister(suite.Repository, time.Hour, 100)
+	suite.Repository.setFail(true)
+
+	persister.save(entity.ActiveClient{ClientId: "127.0.0.1", ClientType: entity.Ip})
+	persister.save(entity.ActiveClient{ClientId: "abc123", ClientType: entity.Token})
+	suite.Error(persister.flush(suite.Ctx))
+
+	persister.save(entity.ActiveClient{ClientId: "abc123", ClientType: entity.Token, Plan: "pro"})
+	suite.Repository.setFail(false)
+	suite.NoError(persister.flush(suite.Ctx))
+
+	activeClients := suite.activeClients()
+	suite.Equal(2, len(activeClients))
+	suite.Equal("pro", activeClients["abc123"].Plan)
+}
+
+func (suite *PersisterTestSuite) TestGivenFullBatch_WhenRunning_ThenShouldFlushBeforeTheInterval() {
+
+	persister := newPersister(suite.Repository, time.Hour, 2)
+	go persister.run(suite.Ctx)
+
+	persister.save(entity.ActiveClient{ClientId: "127.0.0.1", ClientType: entity.Ip})
+	persister.save(entity.ActiveClient{ClientId: "127.0.0.2", ClientType: entity.Ip})
+
+	suite.Eventually(func() bool { return len(suite.activeClients()) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+func (suite *PersisterTestSuite) TestGivenFailingRepository_WhenRunning_ThenShouldRetryWithBackoff() {
+
+	persister := newPersister(suite.Repository, 10*time.Millisecond, 100)
+	suite.Repository.setFail(true)
+	go persister.run(suite.Ctx)
+
+	persister.save(entity.ActiveClient{ClientId: "127.0.0.1", ClientType: entity.Ip})
+
+	time.Sleep(200 * time.Millisecond)
+	suite.Repository.mu.Lock()
+	writes := suite.Repository.writes
+	suite.Repository.mu.Unlock()
+	suite.Less(writes, 6)
+	suite.Empty(suite.activeClients())
+
+	suite.Repository.setFail(false)
+	suite.Eventually(func() bool { return len(suite.activeClients()) == 1 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func (suite *PersisterTestSuite) TestGivenPendingChanges_WhenShutdown_ThenShouldFlush() {
+
+	ctx, cancel := context.WithCancel(suite.Ctx)
+	persister := newPersister(suite.Repository, time.Hour, 100)
+	go persister.run(ctx)
+
+	persister.save(entity.ActiveClient{ClientId: "127.0.0.1", ClientType: entity.Ip})
+	cancel()
+	<-persister.done
+	suite.Equal(1, len(suite.activeClients()))
+
+	closed := newPersister(suite.Repository, time.Hour, 100)
+	go closed.run(suite.Ctx)
+
+	closed.save(entity.ActiveClient{ClientId: "127.0.0.2", ClientType: entity.Ip})
+	suite.NoError(closed.close(suite.Ctx))
+	suite.Equal(2, len(suite.activeClients()))
+}
@@ -0,0 +1,122 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate is an amount of requests allowed in a period, like 10/m or 0.5/s
+type Rate struct {
+	Count  float64
+	Period time.Duration
+}
+
+var ratePeriodUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+}
+
+// ParseRate parses rates in the "<count>/<period>" format. The period is one of
+// the units s, m, h and d, optionally prefixed by a multiplier (10/5m), or any
+// duration accepted by time.ParseDuration
+func ParseRate(value string) (Rate, error) {
+	count, period, found := strings.Cut(strings.TrimSpace(value), "/")
+	if !found {
+		return Rate{}, fmt.Errorf("invalid rate %q: expected <count>/<period>", value)
+	}
+
+	parsedCount, err := strconv.ParseFloat(strings.TrimSpace(count), 64)
+	if err != nil || parsedCount < 0 || math.IsInf(parsedCount, 0) || math.IsNaN(parsedCount) {
+		return Rate{}, fmt.Errorf("invalid rate %q: count must be a non negative number", value)
+	}
+
+	parsedPeriod, err := parseRatePeriod(strings.TrimSpace(period))
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid rate %q: %w", value, err)
+	}
+
+	return Rate{Count: parsedCount, Period: parsedPeriod}, nil
+}
+
+func parseRatePeriod(period string) (time.Duration, error) {
+	if len(period) == 0 {
+		return 0, fmt.Errorf("missing period")
+	}
+
+	unit := period[len(period)-1:]
+	if duration, ok := ratePeriodUnits[unit]; ok {
+		multiplier := 1
+		if len(period) > 1 {
+			parsedMultiplier, err := strconv.Atoi(period[:len(period)-1])
+			if err != nil {
+				return parsePeriodDuration(period)
+			}
+			multiplier = parsedMultiplier
+		}
+		if multiplier <= 0 {
+			return 0, fmt.Errorf("period must be positive")
+		}
+		return time.Duration(multiplier) * duration, nil
+	}
+
+	return parsePeriodDuration(period)
+}
+
+func parsePeriodDuration(period string) (time.Duration, error) {
+	duration, err := time.ParseDuration(period)
+	if err != nil {
+		return 0, fmt.Errorf("unknown period %q", period)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("period must be positive")
+	}
+	return duration, nil
+}
+
+// PerSecond returns a Rate equivalent to the legacy maxReqsPerSecond settings
+func PerSecond(maxReqsPerSecond int) Rate {
+	return Rate{Count: float64(maxReqsPerSecond), Period: time.Second}
+}
+
+func (r Rate) IsZero() bool {
+	return r.Period == 0
+}
+
+// Every returns the interval between two requests at this rate
+func (r Rate) Every() time.Duration {
+	if r.Count <= 0 {
+		return 0
+	}
+	return time.Duration(float64(r.period()) / r.Count)
+}
+
+func (r Rate) PerSecond() float64 {
+	return r.Count / r.period().Seconds()
+}
+
+// In returns how many whole requests fit in the given duration
+func (r Rate) In(duration time.Duration) int {
+	return int(math.Floor(r.Count*float64(duration)/float64(r.period()) + 1e-9))
+}
+
+func (r Rate) String() string {
+	period := r.period()
+	for _, unit := range []string{"d", "h", "m", "s"} {
+		if period == ratePeriodUnits[unit] {
+			return strconv.FormatFloat(r.Count, 'f', -1, 64) + "/" + unit
+		}
+	}
+	return strconv.FormatFloat(r.Count, 'f', -1, 64) + "/" + period.String()
+}
+
+func (r Rate) period() time.Duration {
+	if r.Period <= 0 {
+		return time.Second
+	}
+	return r.Period
+}
@@ -0,0 +1,54 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RateTestSuite struct {
+	suite.Suite
+}
+
+func TestRateSuite(t *testing.T) {
+	suite.Run(t, new(RateTestSuite))
+}
+
+func (suite *RateTestSuite) TestGivenValidRates_WhenParseRate_ThenShouldReturnCountAndPeriod() {
+
+	cases := map[string]Rate{
+		"10/m":     {Count: 10, Period: time.Minute},
+		"1000/d":   {Count: 1000, Period: 24 * time.Hour},
+		"0.5/s":    {Count: 0.5, Period: time.Second},
+		"5/h":      {Count: 5, Period: time.Hour},
+		"10/5m":    {Count: 10, Period: 5 * time.Minute},
+		"100/90s":  {Count: 100, Period: 90 * time.Second},
+		" 3 / 1s ": {Count: 3, Period: time.Second},
+	}
+
+	for value, expected := range cases {
+		rate, err := ParseRate(value)
+		suite.NoError(err, value)
+		suite.Equal(expected, rate, value)
+	}
+}
+
+func (suite *RateTestSuite) TestGivenInvalidRates_WhenParseRate_ThenShouldReturnError() {
+
+	for _, value := range []string{"", "10", "a/s", "-1/s", "10/", "10/x", "10/0m", "10/-1s"} {
+		_, err := ParseRate(value)
+		suite.Error(err, value)
+	}
+}
+
+func (suite *RateTestSuite) TestGivenRate_WhenConverted_ThenShouldKeepPeriod() {
+
+	rate := Rate{Count: 10, Period: time.Minute}
+
+	suite.Equal(6*time.Second, rate.Every())
+	suite.Equal(10, rate.In(time.Minute))
+	suite.Equal(1, rate.In(6*time.Second))
+	suite.Equal("10/m", rate.String())
+	suite.Equal("0.5/s", Rate{Count: 0.5, Period: time.Second}.String())
+}
@@ -8,13 +8,52 @@ import (
 
 	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
 	db "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/infra/database"
-	"golang.org/x/time/rate"
 )
 
+// TokenConfig holds the limits of a token or of a plan. Tokens referencing a
+// Plan get its settings, overridden by the ones set on the token. Tokens past
+// ExpiresAt are handled by the ExpiredTokenPolicy, and MaxIps caps how many
+// IPs may use a token within MaxIpsWindow
+type TokenConfig struct {
+	Plan             string
+	MaxReqsPerSecond int
+	Rate             Rate
+	Rates            []Rate
+	Burst            int
+	Algorithm        string
+	Window           time.Duration
+	BlockingDuration time.Duration
+	ExpiresAt        time.Time
+	MaxIps           int
+	MaxIpsWindow     time.Duration
+}
+
+// RateLimiterConfigs holds the limits of the clients. When Distributed is set
+// the limits are enforced in Redis with GCRA, shared by every replica. The
+// active clients are persisted every PersistInterval or as soon as
+// PersistBatchSize of them changed
 type RateLimiterConfigs struct {
 	BlockingDuration   time.Duration
+	Algorithm          string
+	Window             time.Duration
 	IpMaxReqsPerSecond int
-	TokenConfigs       map[string]int
+	IpRate             Rate
+	IpRates            []Rate
+	IpBurst            int
+	IpV4Prefix         int
+	IpV6Prefix         int
+	TokenConfigs       map[string]TokenConfig
+	Plans              map[string]TokenConfig
+	ExpiredTokenPolicy KeyPolicy
+	UnknownKeyPolicy   KeyPolicy
+	UnknownKeyLimit    TokenConfig
+	EnforceIpLimit     bool
+	Policies           map[string]TokenConfig
+	MaxWait            time.Duration
+	MaxQueue           int
+	Distributed        *RedisLimiter
+	PersistInterval    time.Duration
+	PersistBatchSize   int
 }
 
 type RateLimiter struct {
@@ -22,11 +61,15 @@ type RateLimiter struct {
 	Configs       RateLimiterConfigs
 	Repository    db.RateLimiterRepository
 	activeClients ActiveClients
+	tokenIps      TokenIps
+	waitQueues    WaitQueues
+	persister     *persister
 }
 
 type ActiveClients struct {
-	mu      sync.Mutex
-	clients map[string]entity.ActiveClient
+	mu       sync.Mutex
+	clients  map[string]entity.ActiveClient
+	limiters map[string]Limiter
 }
 
 func NewRateLimiter(
@@ -39,10 +82,14 @@ func NewRateLimiter(
 		Configs:    Configs,
 		Repository: Repository,
 		activeClients: ActiveClients{
-			clients: make(map[string]entity.ActiveClient)}}
+			clients:  make(map[string]entity.ActiveClient),
+			limiters: make(map[string]Limiter)},
+		persister: newPersister(Repository, Configs.PersistInterval, Configs.PersistBatchSize)}
 
 	rateLimiter.loadActiveClients()
 
+	go rateLimiter.persister.run(ctx)
+
 	// Unblock client after expiration time
 	go func() {
 		for {
@@ -117,7 +164,7 @@ func (r *RateLimiter) loadActiveClients() {
 
 	log.Println("Loading active clients...")
 
-	activeClients, err := r.Repository.GetActiveClients()
+	activeClients, err := db.GetActiveClients(r.ctx, r.Repository)
 	if err != nil {
 		log.Println("Error loading active clients. Starting clean.", err)
 		activeClients = make(map[string]entity.ActiveClient)
@@ -126,55 +173,51 @@ func (r *RateLimiter) loadActiveClients() {
 	log.Printf("%d active clients loaded\n", len(activeClients))
 
 	// populate limiters
-	for k := range activeClients {
-		activeClient := activeClients[k]
-		maxReqsPerSecond := 0
-
-		if activeClient.ClientType == entity.Ip {
-			maxReqsPerSecond = r.Configs.IpMaxReqsPerSecond
-		} else {
-			if tokenConfig, ok := r.Configs.TokenConfigs[activeClient.ClientId]; ok {
-				maxReqsPerSecond = tokenConfig
-			}
-		}
-
-		if entry, ok := activeClients[k]; ok {
-			entry.Limiter = getRateLimiter(maxReqsPerSecond)
-			activeClients[k] = entry
-		}
+	limiters := make(map[string]Limiter, len(activeClients))
+	for k, v := range activeClients {
+		limiters[k] = r.getLimiter(v)
+		unmarshalLimiterState(limiters[k], v.LimiterState)
 	}
 
 	r.activeClients.mu.Lock()
 	r.activeClients.clients = activeClients
+	r.activeClients.limiters = limiters
 	r.activeClients.mu.Unlock()
 }
 
-func (r *RateLimiter) saveActiveClients() {
+// saveActiveClient schedules the client to be persisted by the next flush
+func (r *RateLimiter) saveActiveClient(client entity.ActiveClient) {
+	r.persister.save(client)
+}
 
-	r.activeClients.mu.Lock()
-	activeClients := make(map[string]entity.ActiveClient, len(r.activeClients.clients))
+// deleteActiveClient schedules the client to be removed by the next flush
+func (r *RateLimiter) deleteActiveClient(id string) {
+	r.persister.delete(id)
+}
 
-	for k, v := range r.activeClients.clients {
-		activeClients[k] = v
-	}
-	r.activeClients.mu.Unlock()
+// Flush persists the active clients changed since the last flush
+func (r *RateLimiter) Flush(ctx context.Context) error {
+	return r.persister.flush(ctx)
+}
 
-	log.Println("Saving active clients...", activeClients)
-	err := r.Repository.SaveActiveClients(activeClients)
-	if err != nil {
-		panic(err)
-	}
+// Close stops persisting the active clients in the background, persisting
+// the pending ones
+func (r *RateLimiter) Close(ctx context.Context) error {
+	return r.persister.close(ctx)
 }
 
-func (r *RateLimiter) addActiveClient(client entity.ActiveClient) {
+func (r *RateLimiter) addActiveClient(client entity.ActiveClient, limiter Limiter) {
 
 	log.Println("Adding active client", client)
 
+	client.LimiterState = marshalLimiterState(limiter)
+
 	r.activeClients.mu.Lock()
 	r.activeClients.clients[client.ClientId] = client
+	r.activeClients.limiters[client.ClientId] = limiter
 	r.activeClients.mu.Unlock()
 
-	r.saveActiveClients()
+	r.saveActiveClient(client)
 }
 
 func (r *RateLimiter) removeActiveClient(client entity.ActiveClient) {
@@ -183,20 +226,23 @@ func (r *RateLimiter) removeActiveClient(client entity.ActiveClient) {
 
 	r.activeClients.mu.Lock()
 	delete(r.activeClients.clients, client.ClientId)
+	delete(r.activeClients.limiters, client.ClientId)
 	r.activeClients.mu.Unlock()
 
-	r.saveActiveClients()
+	r.deleteActiveClient(client.ClientId)
 }
 
-func (r *RateLimiter) updateActiveClient(client entity.ActiveClient) {
+func (r *RateLimiter) updateActiveClient(client entity.ActiveClient, limiter Limiter) {
 
 	log.Println("Updating active client", client)
 
+	client.LimiterState = marshalLimiterState(limiter)
+
 	r.activeClients.mu.Lock()
 	r.activeClients.clients[client.ClientId] = client
 	r.activeClients.mu.Unlock()
 
-	r.saveActiveClients()
+	r.saveActiveClient(client)
 }
 
 func (r *RateLimiter) unblockActiveClient(key string) {
@@ -204,83 +250,204 @@ func (r *RateLimiter) unblockActiveClient(key string) {
 	log.Println("Unblocking active client", key)
 
 	r.activeClients.mu.Lock()
-	client := r.activeClients.clients[key]
+	client, ok := r.activeClients.clients[key]
+	if !ok {
+		r.activeClients.mu.Unlock()
+		return
+	}
 	client.Blocked = false
 	client.BlockedUntil = time.Time{}
+	client.BlockedBy = ""
 	r.activeClients.clients[key] = client
 	r.activeClients.mu.Unlock()
 
-	r.saveActiveClients()
+	r.saveActiveClient(client)
 }
 
 func (r *RateLimiter) Allow(ipAddr string, apiKeyHeader string) bool {
+	return r.Decide(ipAddr, apiKeyHeader).Allowed
+}
 
-	if apiKeyHeader != "" {
-
-		tokenMaxReqsPerSecond, ok := r.Configs.TokenConfigs[apiKeyHeader]
-		if ok {
-
-			log.Println("tokenMaxReqsPerSecond", tokenMaxReqsPerSecond)
-			return r.verifyClientAllowed(apiKeyHeader, entity.Token, tokenMaxReqsPerSecond)
-		}
-	}
-
-	ipMaxReqsPerSecond := r.Configs.IpMaxReqsPerSecond
-	log.Println("ipMaxReqsPerSecond", ipMaxReqsPerSecond)
-	return r.verifyClientAllowed(ipAddr, entity.Ip, ipMaxReqsPerSecond)
+// Decide verifies if the request is allowed, describing the limit applied to the client
+func (r *RateLimiter) Decide(ipAddr string, apiKeyHeader string) Decision {
+	return r.DecideRequest(Request{IpAddr: ipAddr, Key: apiKeyHeader})
 }
 
-func (r *RateLimiter) verifyClientAllowed(id string, clientType entity.ClientType, maxReqsPerSecond int) bool {
+// verifyClientAllowed applies the limits of the client, created from the
+// given one when it is not active yet, to the request. Denied requests that
+// fit within the MaxWait of the request are delayed instead of blocking the client
+func (r *RateLimiter) verifyClientAllowed(client entity.ActiveClient, request Request) Decision {
+	id := client.ClientId
+	cost := request.cost()
 	log.Println("verifyClientAllowed", id)
 
+	now := time.Now()
+
 	r.activeClients.mu.Lock()
 	activeClient, exists := r.activeClients.clients[id]
+	limiter := r.activeClients.limiters[id]
 	r.activeClients.mu.Unlock()
 
 	if !exists {
-		activeClient = createActiveClient(id, clientType, maxReqsPerSecond)
-		r.addActiveClient(activeClient)
+		activeClient = client
+		limiter = r.getLimiter(activeClient)
+		result := limiter.AllowN(now, cost)
+		if !result.BlockedUntil.IsZero() {
+			activeClient = blockedElsewhere(activeClient, result)
+			r.addActiveClient(activeClient, limiter)
+			return newBlockedDecision(activeClient, StillBlocked, result, now)
+		}
+		// with a distributed limiter other replicas may have used the budget
+		if !result.Allowed && !request.delays(result) && r.Configs.Distributed != nil {
+			activeClient = r.blockClient(activeClient, limiter, result, now)
+			r.addActiveClient(activeClient, limiter)
+			return newBlockedDecision(activeClient, RateExceeded, result, now)
+		}
+		r.addActiveClient(activeClient, limiter)
 		log.Println("Active clients: ", r.activeClients.clients)
-		allow := activeClient.Limiter.Allow()
-		log.Println("Allow", allow)
-		return allow
+		log.Println("Allow", result.Allowed)
+		if request.delays(result) {
+			return newDelayedDecision(activeClient, result)
+		}
+		return newDecision(activeClient, result)
 	}
 
 	log.Println("Existing active client", activeClient)
 
-	activeClient.LastSeen = time.Now()
+	activeClient.LastSeen = now
 
-	if activeClient.Blocked {
-		log.Println("Client is blocked until", activeClient.BlockedUntil)
-		r.updateActiveClient(activeClient)
-		return false
+	if activeClient.Plan != client.Plan {
+		log.Printf("Client %s changed plan from %q to %q\n", id, activeClient.Plan, client.Plan)
+		activeClient.Plan = client.Plan
+		limiter = r.getLimiter(activeClient)
+		r.activeClients.mu.Lock()
+		r.activeClients.limiters[id] = limiter
+		r.activeClients.mu.Unlock()
 	}
 
-	allow := activeClient.Limiter.Allow()
+	if activeClient.Blocked && now.Before(activeClient.BlockedUntil) {
+		log.Printf("Client is blocked by %s until %s\n", activeClient.BlockedBy, activeClient.BlockedUntil)
+		r.updateActiveClient(activeClient, limiter)
+		return newBlockedDecision(activeClient, StillBlocked, r.blockedResult(activeClient), now)
+	}
 
-	if !allow {
-		activeClient.Blocked = true
-		activeClient.BlockedUntil = time.Now().Add(r.Configs.BlockingDuration)
-		log.Printf("Blocking client %s until %s\n", activeClient.ClientId, activeClient.BlockedUntil)
+	activeClient.Blocked = false
+	activeClient.BlockedUntil = time.Time{}
+	activeClient.BlockedBy = ""
+
+	result := limiter.AllowN(now, cost)
+
+	if !result.BlockedUntil.IsZero() {
+		activeClient = blockedElsewhere(activeClient, result)
+		r.updateActiveClient(activeClient, limiter)
+		return newBlockedDecision(activeClient, StillBlocked, result, now)
+	}
+
+	if request.delays(result) {
+		log.Printf("Delaying client %s for %s\n", id, result.RetryAfter)
+		r.updateActiveClient(activeClient, limiter)
+		return newDelayedDecision(activeClient, result)
 	}
 
-	r.updateActiveClient(activeClient)
+	if !result.Allowed {
+		activeClient = r.blockClient(activeClient, limiter, result, now)
+		r.updateActiveClient(activeClient, limiter)
+		return newBlockedDecision(activeClient, RateExceeded, result, now)
+	}
+
+	r.updateActiveClient(activeClient, limiter)
+
+	log.Println("Allow", result.Allowed)
+	return newDecision(activeClient, result)
+}
+
+// blockClient blocks the client by the window that denied the request, sharing
+// the block with the other replicas when the limiter keeps it
+func (r *RateLimiter) blockClient(client entity.ActiveClient, limiter Limiter, result Result, now time.Time) entity.ActiveClient {
+	blockingDuration := r.clientConfig(client).BlockingDuration
+	client.Blocked = true
+	client.BlockedUntil = now.Add(blockingDuration)
+	client.BlockedBy = result.Limit.String()
+	log.Printf("Blocking client %s by %s until %s\n", client.ClientId, client.BlockedBy, client.BlockedUntil)
+	if blocker, ok := limiter.(blocker); ok {
+		blocker.block(result.Limit, blockingDuration)
+	}
+	return client
+}
 
-	log.Println("Allow", allow)
-	return allow
+// blockedElsewhere marks the client as blocked by the limiter keeping its block,
+// as another replica may have blocked it
+func blockedElsewhere(client entity.ActiveClient, result Result) entity.ActiveClient {
+	log.Printf("Client %s is blocked by %s until %s\n", client.ClientId, result.Limit, result.BlockedUntil)
+	client.Blocked = true
+	client.BlockedUntil = result.BlockedUntil
+	client.BlockedBy = result.Limit.String()
+	return client
 }
 
-func createActiveClient(id string, clientType entity.ClientType, maxReqsPerSeconds int) entity.ActiveClient {
+func createActiveClient(id string, clientType entity.ClientType, plan string) entity.ActiveClient {
 	return entity.ActiveClient{
 		ClientId:     id,
 		LastSeen:     time.Now(),
 		ClientType:   clientType,
+		Plan:         plan,
 		BlockedUntil: time.Time{},
 		Blocked:      false,
-		Limiter:      getRateLimiter(maxReqsPerSeconds),
 	}
 }
 
-func getRateLimiter(maxReqsPerSecond int) *rate.Limiter {
-	return rate.NewLimiter(rate.Limit(maxReqsPerSecond), maxReqsPerSecond)
+// getLimiter builds the limiter of the policy configured for the client
+func (r *RateLimiter) getLimiter(client entity.ActiveClient) Limiter {
+	config := r.clientConfig(client)
+
+	limits := clientLimits(config)
+	if r.Configs.Distributed != nil {
+		return r.Configs.Distributed.newLimiter(client.ClientId, limits)
+	}
+	return newLimiter(AlgorithmStrategy(config.Algorithm), limits)
+}
+
+// blockedResult describes the window that blocked the client with the quota
+// its limiter reports, so the quota does not change while the client is blocked
+func (r *RateLimiter) blockedResult(client entity.ActiveClient) Result {
+	config := r.clientConfig(client)
+	for _, limit := range clientLimits(config) {
+		if limit.Rate.String() != client.BlockedBy {
+			continue
+		}
+		if r.Configs.Distributed != nil {
+			return Result{Limit: limit.Rate, Quota: limit.burst()}
+		}
+		return Result{Limit: limit.Rate, Quota: limit.quota(AlgorithmStrategy(config.Algorithm))}
+	}
+	return Result{}
+}
+
+func clientLimits(config TokenConfig) []Limit {
+	rate := config.Rate
+	if rate.IsZero() {
+		rate = PerSecond(config.MaxReqsPerSecond)
+	}
+	return getLimits(rate, config.Rates, config.Burst, config.Window)
+}
+
+// getLimits returns one limit per window. When several rates are configured
+// they replace the single rate, the burst applies to the shortest window and
+// each window is as long as the period of its rate
+func getLimits(rate Rate, rates []Rate, burst int, window time.Duration) []Limit {
+	if len(rates) == 0 {
+		return []Limit{{Rate: rate, Burst: burst, Window: window}}
+	}
+
+	limits := make([]Limit, 0, len(rates))
+	shortest := 0
+	for i, rate := range rates {
+		limits = append(limits, Limit{Rate: rate})
+		if rate.period() < rates[shortest].period() {
+			shortest = i
+		}
+	}
+	limits[shortest].Burst = burst
+
+	return limits
 }
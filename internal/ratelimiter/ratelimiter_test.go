@@ -3,9 +3,12 @@ package ratelimiter
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
 	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
 	db "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/infra/database"
 	"github.com/stretchr/testify/suite"
@@ -19,26 +22,18 @@ type RateLimiterTestSuite struct {
 	Repository db.RateLimiterRepository
 }
 
-func (suite *RateLimiterTestSuite) SetupSuite() {
+func (suite *RateLimiterTestSuite) SetupTest() {
 	suite.Ctx, suite.Cancel = context.WithCancel(context.Background())
 	client, err := sql.Open("sqlite3", ":memory:")
 	suite.NoError(err)
-	client.Exec("CREATE TABLE active_client (ClientId TEXT NOT NULL, LastSeen DATETIME NOT NULL, ClientType INTEGER NOT NULL, BlockedUntil DATETIME, Blocked BOOLEAN NOT NULL)")
+	client.SetMaxOpenConns(1)
+	suite.NoError(db.MigrateSQLite(suite.Ctx, client))
 	suite.Db = client
-}
-
-func (suite *RateLimiterTestSuite) SetupTest() {
-	suite.Db.Ping()
-	suite.Repository = db.NewRateLimiterSQLiteRepository(suite.Ctx, suite.Db)
+	suite.Repository = db.NewRateLimiterSQLiteRepository(suite.Db)
 }
 
 func (suite *RateLimiterTestSuite) TearDownTest() {
 	suite.Cancel()
-	suite.Ctx, suite.Cancel = context.WithCancel(context.Background())
-	suite.Db.Exec("DELETE FROM active_client")
-}
-
-func (suite *RateLimiterTestSuite) TearDownSuite() {
 	suite.Db.Close()
 }
 
@@ -52,7 +47,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddress_WhenAllow_ThenShouldSaveIp
 
 	rateLimiter.Allow("127.0.0.1", "")
 
-	activeClients, err := suite.Repository.GetActiveClients()
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
 
 	suite.NoError(err)
 	suite.NotEmpty(activeClients)
@@ -66,8 +62,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddressAndToken_WhenAllow_ThenShou
 	configs := RateLimiterConfigs{
 		IpMaxReqsPerSecond: 1,
 		BlockingDuration:   5 * time.Second,
-		TokenConfigs: map[string]int{
-			"abc123": 1,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {MaxReqsPerSecond: 1},
 		},
 	}
 
@@ -75,7 +71,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddressAndToken_WhenAllow_ThenShou
 
 	rateLimiter.Allow("127.0.0.1", "abc123")
 
-	activeClients, err := suite.Repository.GetActiveClients()
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
 
 	suite.NoError(err)
 	suite.NotEmpty(activeClients)
@@ -99,11 +96,13 @@ func (suite *RateLimiterTestSuite) TestGivenExistingActiveClients_WhenCreateRate
 		},
 	}
 
-	suite.Repository.SaveActiveClients(clients)
+	for _, client := range clients {
+		suite.NoError(suite.Repository.Put(suite.Ctx, client))
+	}
 
 	NewRateLimiter(suite.Ctx, RateLimiterConfigs{}, suite.Repository)
 
-	activeClients, err := suite.Repository.GetActiveClients()
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
 
 	suite.NoError(err)
 	suite.NotEmpty(activeClients)
@@ -133,13 +132,16 @@ func (suite *RateLimiterTestSuite) TestGivenExistingActiveClientsWithBlockingExp
 		},
 	}
 
-	suite.Repository.SaveActiveClients(clients)
+	for _, client := range clients {
+		suite.NoError(suite.Repository.Put(suite.Ctx, client))
+	}
 
-	NewRateLimiter(suite.Ctx, RateLimiterConfigs{}, suite.Repository)
+	rateLimiter := NewRateLimiter(suite.Ctx, RateLimiterConfigs{}, suite.Repository)
 
 	time.Sleep(2 * time.Second)
 
-	activeClients, err := suite.Repository.GetActiveClients()
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
 
 	suite.NoError(err)
 	suite.NotEmpty(activeClients)
@@ -157,8 +159,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddress_WhenAllowCalledTwiceWithin
 	configs := RateLimiterConfigs{
 		IpMaxReqsPerSecond: 1,
 		BlockingDuration:   30 * time.Second,
-		TokenConfigs: map[string]int{
-			"abc123": 1,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {MaxReqsPerSecond: 1},
 		},
 	}
 
@@ -171,7 +173,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddress_WhenAllowCalledTwiceWithin
 
 	response = rateLimiter.Allow("127.0.0.1", "")
 
-	activeClients, err := suite.Repository.GetActiveClients()
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
 
 	suite.NoError(err)
 	suite.NotEmpty(activeClients)
@@ -187,8 +190,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddress_WhenClientBlocked_ThenShou
 	configs := RateLimiterConfigs{
 		IpMaxReqsPerSecond: 1,
 		BlockingDuration:   3 * time.Second,
-		TokenConfigs: map[string]int{
-			"abc123": 1,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {MaxReqsPerSecond: 1},
 		},
 	}
 
@@ -201,7 +204,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddress_WhenClientBlocked_ThenShou
 
 	response = rateLimiter.Allow("127.0.0.1", "")
 
-	activeClients, err := suite.Repository.GetActiveClients()
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
 
 	suite.NoError(err)
 	suite.NotEmpty(activeClients)
@@ -216,7 +220,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddress_WhenClientBlocked_ThenShou
 	response = rateLimiter.Allow("127.0.0.1", "")
 	suite.True(response)
 
-	activeClients, err = suite.Repository.GetActiveClients()
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err = db.GetActiveClients(suite.Ctx, suite.Repository)
 
 	suite.NoError(err)
 	suite.NotEmpty(activeClients)
@@ -231,8 +236,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddressAndToken_WhenClientBlocked_
 	configs := RateLimiterConfigs{
 		IpMaxReqsPerSecond: 1,
 		BlockingDuration:   3 * time.Second,
-		TokenConfigs: map[string]int{
-			"abc123": 2,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {MaxReqsPerSecond: 2},
 		},
 	}
 
@@ -251,7 +256,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddressAndToken_WhenClientBlocked_
 	response = rateLimiter.Allow("127.0.0.1", "abc123")
 	suite.False(response)
 
-	activeClients, err := suite.Repository.GetActiveClients()
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
 
 	suite.NoError(err)
 	suite.NotEmpty(activeClients)
@@ -266,7 +272,8 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddressAndToken_WhenClientBlocked_
 	response = rateLimiter.Allow("127.0.0.1", "abc123")
 	suite.True(response)
 
-	activeClients, err = suite.Repository.GetActiveClients()
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err = db.GetActiveClients(suite.Ctx, suite.Repository)
 
 	suite.NoError(err)
 	suite.NotEmpty(activeClients)
@@ -275,3 +282,502 @@ func (suite *RateLimiterTestSuite) TestGivenIpAddressAndToken_WhenClientBlocked_
 	suite.Equal(entity.Token, activeClients["abc123"].ClientType)
 	suite.False(activeClients["abc123"].Blocked)
 }
+
+func (suite *RateLimiterTestSuite) TestGivenTokenWithFixedWindowAlgorithm_WhenLimitReached_ThenShouldUseTokenAlgorithm() {
+
+	configs := RateLimiterConfigs{
+		Algorithm:          TokenBucket,
+		IpMaxReqsPerSecond: 1,
+		BlockingDuration:   30 * time.Second,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {MaxReqsPerSecond: 2, Algorithm: FixedWindow},
+		},
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	rateLimiter.Allow("127.0.0.1", "abc123")
+
+	rateLimiter.activeClients.mu.Lock()
+	limiter := rateLimiter.activeClients.limiters["abc123"]
+	rateLimiter.activeClients.mu.Unlock()
+
+	suite.IsType(&fixedWindowLimiter{}, limiter)
+}
+
+func (suite *RateLimiterTestSuite) TestGivenGCRAAlgorithm_WhenRateLimiterRestarted_ThenShouldRestoreLimiterState() {
+
+	configs := RateLimiterConfigs{
+		Algorithm:          GCRA,
+		IpMaxReqsPerSecond: 1,
+		BlockingDuration:   0,
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+	suite.True(rateLimiter.Allow("127.0.0.1", ""))
+
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
+
+	suite.NoError(err)
+	suite.NotEmpty(activeClients["127.0.0.1"].LimiterState)
+
+	suite.Db.Exec("DELETE FROM active_client")
+	for _, client := range activeClients {
+		suite.NoError(suite.Repository.Put(suite.Ctx, client))
+	}
+
+	restarted := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+	suite.False(restarted.Allow("127.0.0.1", ""))
+}
+
+func (suite *RateLimiterTestSuite) TestGivenSeveralIpRates_WhenLongerWindowExhausted_ThenShouldDenyAndReportTrippedWindow() {
+
+	perSecond := Rate{Count: 10, Period: time.Second}
+	perMinute := Rate{Count: 2, Period: time.Minute}
+	configs := RateLimiterConfigs{
+		IpRates:          []Rate{perSecond, perMinute},
+		BlockingDuration: 30 * time.Second,
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	suite.True(rateLimiter.Allow("127.0.0.1", ""))
+	suite.True(rateLimiter.Allow("127.0.0.1", ""))
+
+	decision := rateLimiter.Decide("127.0.0.1", "")
+	suite.False(decision.Allowed)
+	suite.Equal(perMinute, decision.Limit)
+
+	decision = rateLimiter.Decide("127.0.0.1", "")
+	suite.False(decision.Allowed)
+	suite.Equal(perMinute, decision.Limit)
+
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
+
+	suite.NoError(err)
+	suite.True(activeClients["127.0.0.1"].Blocked)
+	suite.Equal("2/m", activeClients["127.0.0.1"].BlockedBy)
+}
+
+func (suite *RateLimiterTestSuite) TestGivenIpAddress_WhenDecide_ThenShouldDescribeClientAndReason() {
+
+	configs := RateLimiterConfigs{
+		IpMaxReqsPerSecond: 2,
+		BlockingDuration:   30 * time.Second,
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	decision := rateLimiter.Decide("127.0.0.1", "")
+	suite.True(decision.Allowed)
+	suite.Equal(Reason(""), decision.Reason)
+	suite.Equal("127.0.0.1", decision.ClientId)
+	suite.Equal(entity.Ip, decision.ClientType)
+	suite.Equal(PerSecond(2), decision.Limit)
+	suite.Equal(2, decision.Quota)
+	suite.Equal(1, decision.Remaining)
+
+	rateLimiter.Decide("127.0.0.1", "")
+
+	decision = rateLimiter.Decide("127.0.0.1", "")
+	suite.False(decision.Allowed)
+	suite.Equal(RateExceeded, decision.Reason)
+	suite.Equal(0, decision.Remaining)
+	suite.InDelta(30*time.Second, decision.RetryAfter, float64(time.Second))
+
+	decision = rateLimiter.Decide("127.0.0.1", "")
+	suite.False(decision.Allowed)
+	suite.Equal(StillBlocked, decision.Reason)
+	suite.Equal(PerSecond(2), decision.Limit)
+	suite.Equal(2, decision.Quota)
+	suite.InDelta(30*time.Second, decision.RetryAfter, float64(time.Second))
+	suite.WithinDuration(time.Now().Add(30*time.Second), decision.ResetAt, time.Second)
+}
+
+func (suite *RateLimiterTestSuite) TestGivenBurstOrWindow_WhenClientStillBlocked_ThenShouldKeepTheQuota() {
+
+	for i, test := range []struct {
+		algorithm string
+		quota     int
+	}{{TokenBucket, 5}, {GCRA, 5}, {FixedWindow, 10}, {SlidingWindowLog, 10}} {
+		algorithm, quota := test.algorithm, test.quota
+		suite.Run(algorithm, func() {
+			configs := RateLimiterConfigs{
+				IpRate:           Rate{Count: 1, Period: time.Second},
+				IpBurst:          5,
+				Algorithm:        algorithm,
+				Window:           10 * time.Second,
+				BlockingDuration: 30 * time.Second,
+			}
+
+			rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+			ip := fmt.Sprintf("127.0.0.%d", i+1)
+
+			for i := 0; i < quota; i++ {
+				suite.Equal(quota, rateLimiter.Decide(ip, "").Quota)
+			}
+
+			decision := rateLimiter.Decide(ip, "")
+			suite.Equal(RateExceeded, decision.Reason)
+			suite.Equal(quota, decision.Quota)
+
+			decision = rateLimiter.Decide(ip, "")
+			suite.Equal(StillBlocked, decision.Reason)
+			suite.Equal(Rate{Count: 1, Period: time.Second}, decision.Limit)
+			suite.Equal(quota, decision.Quota)
+		})
+	}
+}
+
+func (suite *RateLimiterTestSuite) TestGivenIpPrefixes_WhenAllow_ThenShouldAggregateClientsByNetwork() {
+
+	configs := RateLimiterConfigs{
+		IpMaxReqsPerSecond: 1,
+		BlockingDuration:   30 * time.Second,
+		IpV4Prefix:         24,
+		IpV6Prefix:         64,
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	suite.True(rateLimiter.Allow("2001:db8:1:2::1", ""))
+	suite.False(rateLimiter.Allow("2001:db8:1:2:ffff::1", ""))
+	suite.True(rateLimiter.Allow("2001:db8:1:3::1", ""))
+
+	suite.True(rateLimiter.Allow("::ffff:192.0.2.1", ""))
+	suite.False(rateLimiter.Allow("192.0.2.200", ""))
+
+	suite.NoError(rateLimiter.Flush(suite.Ctx))
+	activeClients, err := db.GetActiveClients(suite.Ctx, suite.Repository)
+
+	suite.NoError(err)
+	suite.Equal(3, len(activeClients))
+	suite.Contains(activeClients, "2001:db8:1:2::/64")
+	suite.Contains(activeClients, "2001:db8:1:3::/64")
+	suite.Contains(activeClients, "192.0.2.0/24")
+}
+
+func (suite *RateLimiterTestSuite) TestGivenNoIpPrefixes_WhenAllow_ThenShouldKeyOnFullAddress() {
+
+	rateLimiter := NewRateLimiter(suite.Ctx, RateLimiterConfigs{IpMaxReqsPerSecond: 1}, suite.Repository)
+
+	suite.Equal("192.0.2.1", rateLimiter.ipKey("::ffff:192.0.2.1"))
+	suite.Equal("2001:db8::1", rateLimiter.ipKey("2001:DB8::1"))
+	suite.Equal("not-an-ip", rateLimiter.ipKey("not-an-ip"))
+}
+
+func (suite *RateLimiterTestSuite) TestGivenTokenWithPlan_WhenLimitReached_ThenShouldUsePlanLimitsAndBlockingDuration() {
+
+	configs := RateLimiterConfigs{
+		IpMaxReqsPerSecond: 1,
+		BlockingDuration:   30 * time.Second,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {Plan: "pro"},
+			"abc321": {Plan: "pro", MaxReqsPerSecond: 1},
+		},
+		Plans: map[string]TokenConfig{
+			"pro": {MaxReqsPerSecond: 3, BlockingDuration: 5 * time.Second},
+		},
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	for i := 0; i < 3; i++ {
+		suite.True(rateLimiter.Allow("127.0.0.1", "abc123"))
+	}
+
+	now := time.Now()
+	decision := rateLimiter.Decide("127.0.0.1", "abc123")
+
+	suite.False(decision.Allowed)
+	suite.Equal(3, decision.Quota)
+	suite.WithinDuration(now.Add(5*time.Second), decision.ResetAt, time.Second)
+
+	suite.True(rateLimiter.Allow("127.0.0.1", "abc321"))
+	suite.False(rateLimiter.Allow("127.0.0.1", "abc321"))
+}
+
+func (suite *RateLimiterTestSuite) TestGivenTokenConfigOverridingPlan_WhenClientConfig_ThenShouldMergeSettings() {
+
+	configs := RateLimiterConfigs{
+		Algorithm:        TokenBucket,
+		BlockingDuration: 30 * time.Second,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {Plan: "pro", Burst: 50},
+		},
+		Plans: map[string]TokenConfig{
+			"pro": {Rate: Rate{Count: 20, Period: time.Second}, Burst: 20, Algorithm: GCRA},
+		},
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	config := rateLimiter.clientConfig(createActiveClient("abc123", entity.Token, ""))
+
+	suite.Equal("pro", config.Plan)
+	suite.Equal(Rate{Count: 20, Period: time.Second}, config.Rate)
+	suite.Equal(50, config.Burst)
+	suite.Equal(GCRA, config.Algorithm)
+	suite.Equal(30*time.Second, config.BlockingDuration)
+}
+
+func (suite *RateLimiterTestSuite) TestGivenExpiredToken_WhenDecide_ThenShouldApplyExpiredTokenPolicy() {
+
+	configs := RateLimiterConfigs{
+		IpMaxReqsPerSecond: 1,
+		BlockingDuration:   30 * time.Second,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {MaxReqsPerSecond: 10, ExpiresAt: time.Now().Add(-time.Minute)},
+			"abc321": {MaxReqsPerSecond: 10, ExpiresAt: time.Now().Add(time.Hour)},
+		},
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	decision := rateLimiter.Decide("127.0.0.1", "abc123")
+	suite.True(decision.Allowed)
+	suite.Equal(entity.Ip, decision.ClientType)
+	suite.Equal("127.0.0.1", decision.ClientId)
+
+	decision = rateLimiter.Decide("127.0.0.1", "abc321")
+	suite.True(decision.Allowed)
+	suite.Equal(entity.Token, decision.ClientType)
+
+	rateLimiter.Configs.ExpiredTokenPolicy = RejectKey
+
+	decision = rateLimiter.Decide("127.0.0.2", "abc123")
+	suite.False(decision.Allowed)
+	suite.True(decision.Rejected())
+	suite.Equal(TokenExpired, decision.Reason)
+	suite.Equal("abc123", decision.ClientId)
+
+	rateLimiter.activeClients.mu.Lock()
+	_, exists := rateLimiter.activeClients.clients["127.0.0.2"]
+	rateLimiter.activeClients.mu.Unlock()
+	suite.False(exists)
+}
+
+func (suite *RateLimiterTestSuite) TestGivenEnforceIpLimit_WhenTokenUsedFromSeveralIps_ThenShouldCapEachIp() {
+
+	configs := RateLimiterConfigs{
+		IpMaxReqsPerSecond: 1,
+		BlockingDuration:   30 * time.Second,
+		EnforceIpLimit:     true,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {MaxReqsPerSecond: 10},
+		},
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	decision := rateLimiter.Decide("127.0.0.1", "abc123")
+	suite.True(decision.Allowed)
+	suite.Equal(entity.Token, decision.ClientType)
+	suite.Equal(10, decision.Quota)
+
+	decision = rateLimiter.Decide("127.0.0.1", "abc123")
+	suite.False(decision.Allowed)
+	suite.Equal(entity.Ip, decision.ClientType)
+	suite.Equal(RateExceeded, decision.Reason)
+
+	suite.True(rateLimiter.Allow("127.0.0.2", "abc123"))
+}
+
+func (suite *RateLimiterTestSuite) TestGivenEnforceIpLimit_WhenTokenLimitExceeded_ThenShouldNotChargeTheIp() {
+
+	configs := RateLimiterConfigs{
+		IpMaxReqsPerSecond: 10,
+		BlockingDuration:   30 * time.Second,
+		EnforceIpLimit:     true,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {MaxReqsPerSecond: 1},
+		},
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	suite.True(rateLimiter.Allow("127.0.0.1", "abc123"))
+	for i := 0; i < 5; i++ {
+		decision := rateLimiter.Decide("127.0.0.1", "abc123")
+		suite.False(decision.Allowed)
+		suite.Equal(entity.Token, decision.ClientType)
+	}
+
+	decision := rateLimiter.Decide("127.0.0.1", "")
+	suite.True(decision.Allowed)
+	suite.Equal(8, decision.Remaining)
+}
+
+func (suite *RateLimiterTestSuite) TestGivenTokenWithMaxIps_WhenUsedFromMoreIps_ThenShouldRejectNewIps() {
+
+	configs := RateLimiterConfigs{
+		IpMaxReqsPerSecond: 1,
+		BlockingDuration:   30 * time.Second,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {MaxReqsPerSecond: 10, MaxIps: 2, MaxIpsWindow: time.Second},
+		},
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	suite.True(rateLimiter.Allow("127.0.0.1", "abc123"))
+	suite.True(rateLimiter.Allow("127.0.0.2", "abc123"))
+	suite.True(rateLimiter.Allow("127.0.0.1", "abc123"))
+
+	decision := rateLimiter.Decide("127.0.0.3", "abc123")
+	suite.True(decision.Rejected())
+	suite.Equal(TooManyIps, decision.Reason)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	suite.True(rateLimiter.Allow("127.0.0.3", "abc123"))
+}
+
+func (suite *RateLimiterTestSuite) TestGivenMaxWait_WhenWait_ThenShouldDelayRequestUntilAllowed() {
+
+	configs := RateLimiterConfigs{
+		IpRate:           Rate{Count: 2, Period: time.Second},
+		IpBurst:          1,
+		BlockingDuration: 30 * time.Second,
+		MaxQueue:         1,
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+	request := Request{IpAddr: "127.0.0.1", MaxWait: time.Second}
+
+	suite.True(rateLimiter.Wait(suite.Ctx, request).Allowed)
+
+	start := time.Now()
+	decision := rateLimiter.Wait(suite.Ctx, request)
+	suite.True(decision.Allowed)
+	suite.InDelta(500*time.Millisecond, time.Since(start), float64(100*time.Millisecond))
+
+	waited := make(chan Decision)
+	go func() { waited <- rateLimiter.Wait(suite.Ctx, request) }()
+	time.Sleep(50 * time.Millisecond)
+
+	decision = rateLimiter.Wait(suite.Ctx, request)
+	suite.False(decision.Allowed)
+	suite.Equal(QueueFull, decision.Reason)
+	suite.True((<-waited).Allowed)
+
+	ctx, cancel := context.WithTimeout(suite.Ctx, 50*time.Millisecond)
+	defer cancel()
+	decision = rateLimiter.Wait(ctx, request)
+	suite.Equal(Canceled, decision.Reason)
+
+	rateLimiter.activeClients.mu.Lock()
+	blocked := rateLimiter.activeClients.clients["127.0.0.1"].Blocked
+	rateLimiter.activeClients.mu.Unlock()
+	suite.False(blocked)
+}
+
+func (suite *RateLimiterTestSuite) TestGivenEnforceIpLimitAndMaxWait_WhenTokenRequestDelayed_ThenShouldChargeTheIpOnce() {
+
+	configs := RateLimiterConfigs{
+		IpRate:           Rate{Count: 10, Period: time.Minute},
+		BlockingDuration: 30 * time.Second,
+		EnforceIpLimit:   true,
+		TokenConfigs: map[string]TokenConfig{
+			"abc123": {Rate: Rate{Count: 4, Period: time.Second}, Burst: 1},
+		},
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+	request := Request{IpAddr: "127.0.0.1", Key: "abc123", MaxWait: time.Second}
+
+	for i := 0; i < 4; i++ {
+		decision := rateLimiter.Wait(suite.Ctx, request)
+		suite.True(decision.Allowed)
+		suite.Equal(entity.Token, decision.ClientType)
+	}
+
+	decision := rateLimiter.Decide("127.0.0.1", "")
+	suite.True(decision.Allowed)
+	suite.Equal(5, decision.Remaining)
+}
+
+func (suite *RateLimiterTestSuite) TestGivenMaxWait_WhenWaitWouldExceedIt_ThenShouldDenyAndBlock() {
+
+	configs := RateLimiterConfigs{
+		IpRate:           Rate{Count: 1, Period: time.Minute},
+		BlockingDuration: 30 * time.Second,
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+	request := Request{IpAddr: "127.0.0.1", MaxWait: time.Second}
+
+	suite.True(rateLimiter.Wait(suite.Ctx, request).Allowed)
+
+	start := time.Now()
+	decision := rateLimiter.Wait(suite.Ctx, request)
+	suite.False(decision.Allowed)
+	suite.Equal(RateExceeded, decision.Reason)
+	suite.Less(time.Since(start), 100*time.Millisecond)
+
+	rateLimiter.activeClients.mu.Lock()
+	blocked := rateLimiter.activeClients.clients["127.0.0.1"].Blocked
+	rateLimiter.activeClients.mu.Unlock()
+	suite.True(blocked)
+}
+
+func (suite *RateLimiterTestSuite) TestGivenDistributedLimiter_WhenRedisUnreachable_ThenShouldAllowRequests() {
+
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer client.Close()
+
+	configs := RateLimiterConfigs{
+		IpMaxReqsPerSecond: 1,
+		BlockingDuration:   30 * time.Second,
+		Distributed:        NewRedisLimiter(client, "test"),
+	}
+
+	rateLimiter := NewRateLimiter(suite.Ctx, configs, suite.Repository)
+
+	suite.True(rateLimiter.Allow("127.0.0.1", ""))
+	suite.True(rateLimiter.Allow("127.0.0.1", ""))
+
+	rateLimiter.activeClients.mu.Lock()
+	limiter := rateLimiter.activeClients.limiters["127.0.0.1"]
+	rateLimiter.activeClients.mu.Unlock()
+
+	suite.IsType(&redisClientLimiter{}, limiter)
+	suite.Equal("test:limiter:127.0.0.1", limiter.(*redisClientLimiter).key)
+}
+
+func (suite *RateLimiterTestSuite) TestGivenDistributedLimiter_WhenAReplicaBlocksClient_ThenShouldBeBlockedOnTheOthers() {
+
+	server := miniredis.RunT(suite.T())
+	newReplica := func() *RateLimiter {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		suite.T().Cleanup(func() { client.Close() })
+		configs := RateLimiterConfigs{
+			IpRate:           Rate{Count: 1, Period: time.Minute},
+			BlockingDuration: 30 * time.Second,
+			Distributed:      NewRedisLimiter(client, "test"),
+		}
+		return NewRateLimiter(suite.Ctx, configs, suite.Repository)
+	}
+	replica, otherReplica, newcomer := newReplica(), newReplica(), newReplica()
+
+	suite.True(replica.Allow("127.0.0.1", ""))
+	suite.False(otherReplica.Allow("127.0.0.1", ""))
+
+	decision := newcomer.Decide("127.0.0.1", "")
+	suite.False(decision.Allowed)
+	suite.Equal(StillBlocked, decision.Reason)
+	suite.Equal("1/m", decision.Limit.String())
+	suite.InDelta(30*time.Second, decision.RetryAfter, float64(time.Second))
+
+	decision = replica.Decide("127.0.0.1", "")
+	suite.False(decision.Allowed)
+	suite.Equal(StillBlocked, decision.Reason)
+
+	replica.activeClients.mu.Lock()
+	blocked := replica.activeClients.clients["127.0.0.1"]
+	replica.activeClients.mu.Unlock()
+	suite.True(blocked.Blocked)
+	suite.Equal("1/m", blocked.BlockedBy)
+}
@@ -0,0 +1,185 @@
+package ratelimiter
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisTimeout bounds each check, so a slow Redis does not hold requests
+const redisTimeout = 100 * time.Millisecond
+
+// redisGCRA checks and consumes every window of a client at once. KEYS[1] is
+// the hash with the TAT of each window and the blocked_until and blocked_by
+// (window) of a blocked client, in microseconds of the Redis clock. ARGV holds
+// the cost followed by the emission interval and burst tolerance of each
+// window. Scripts read the Redis clock, so replicas with skewed clocks still
+// agree. Returns allowed, deciding window, remaining, reset, retry after and
+// blocked, reset and retry after in microseconds (retry after -1 when it never
+// fits). Blocked clients are denied without consuming any window
+var redisGCRA = redis.NewScript(`
+redis.replicate_commands()
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local n = tonumber(ARGV[1])
+local windows = (#ARGV - 1) / 2
+
+local blockedUntil = tonumber(redis.call('HGET', KEYS[1], 'blocked_until'))
+if blockedUntil and blockedUntil > now then
+	local blockedBy = tonumber(redis.call('HGET', KEYS[1], 'blocked_by')) or 1
+	return {0, blockedBy, 0, blockedUntil - now, blockedUntil - now, 1}
+end
+
+local tats = {}
+local window = 1
+local remaining = -1
+local reset = 0
+for i = 1, windows do
+	local interval = tonumber(ARGV[2 * i])
+	local tolerance = tonumber(ARGV[2 * i + 1])
+	local tat = tonumber(redis.call('HGET', KEYS[1], i)) or now
+	if tat < now then
+		tat = now
+	end
+
+	local newTat = tat + interval * n
+	if newTat - interval - now > tolerance then
+		local retryAfter = -1
+		if interval * (n - 1) <= tolerance then
+			retryAfter = newTat - interval - now - tolerance
+		end
+		return {0, i, 0, tat - now, retryAfter, 0}
+	end
+
+	tats[i] = newTat
+	local windowRemaining = math.floor((tolerance - (newTat - now)) / interval) + 1
+	if remaining < 0 or windowRemaining < remaining then
+		window = i
+		remaining = windowRemaining
+	end
+	if newTat - now > reset then
+		reset = newTat - now
+	end
+end
+
+for i = 1, windows do
+	redis.call('HSET', KEYS[1], i, string.format('%.0f', tats[i]))
+end
+redis.call('PEXPIRE', KEYS[1], math.ceil(reset / 1000) + 1000)
+return {1, window, remaining, reset, 0, 0}
+`)
+
+// redisBlock blocks the client of the KEYS[1] hash by the window ARGV[1] for
+// ARGV[2] microseconds of the Redis clock, keeping the hash at least as long
+var redisBlock = redis.NewScript(`
+redis.replicate_commands()
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local duration = tonumber(ARGV[2])
+
+redis.call('HSET', KEYS[1], 'blocked_until', string.format('%.0f', now + duration), 'blocked_by', ARGV[1])
+local ttl = math.ceil(duration / 1000) + 1000
+if redis.call('PTTL', KEYS[1]) < ttl then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return 1
+`)
+
+// RedisLimiter enforces the limits of every client in Redis with GCRA, so all
+// the replicas share one budget per client. Keys are <prefix>:limiter:<client>
+type RedisLimiter struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisLimiter(client *redis.Client, prefix string) *RedisLimiter {
+	return &RedisLimiter{client: client, prefix: prefix}
+}
+
+// newLimiter returns the limiter of the client windows, sorted shortest first
+func (l *RedisLimiter) newLimiter(id string, limits []Limit) Limiter {
+	sortLimits(limits)
+
+	limiter := &redisClientLimiter{
+		client: l.client,
+		key:    l.prefix + ":limiter:" + id,
+		limits: limits,
+		args:   make([]interface{}, 0, 2*len(limits)),
+	}
+	for _, limit := range limits {
+		emissionInterval := limit.Rate.Every()
+		burstTolerance := emissionInterval * time.Duration(max(limit.burst()-1, 0))
+		limiter.args = append(limiter.args, emissionInterval.Microseconds(), burstTolerance.Microseconds())
+		limiter.quotas = append(limiter.quotas, limit.burst())
+	}
+	return limiter
+}
+
+type redisClientLimiter struct {
+	client *redis.Client
+	key    string
+	limits []Limit
+	quotas []int
+	args   []interface{}
+}
+
+// AllowN runs the check on Redis. Requests are allowed when Redis can not be
+// reached, so an outage does not take the service down
+func (l *redisClientLimiter) AllowN(now time.Time, n int) Result {
+	for i, limit := range l.limits {
+		if limit.Rate.Every() <= 0 || l.quotas[i] <= 0 {
+			return Result{Allowed: false, Limit: limit.Rate, ResetAt: now}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	args := append([]interface{}{n}, l.args...)
+	values, err := redisGCRA.Run(ctx, l.client, []string{l.key}, args...).Int64Slice()
+	if err != nil || len(values) != 6 {
+		log.Println("Error running rate limiter script on Redis. Allowing request", l.key, err)
+		return Result{Allowed: true, Limit: l.limits[0].Rate, Quota: l.quotas[0], ResetAt: now}
+	}
+
+	// the windows may have changed since the client was blocked
+	window := min(max(int(values[1]), 1), len(l.limits)) - 1
+	result := Result{
+		Allowed:   values[0] == 1,
+		Limit:     l.limits[window].Rate,
+		Quota:     l.quotas[window],
+		Remaining: int(values[2]),
+		ResetAt:   now.Add(time.Duration(values[3]) * time.Microsecond),
+	}
+	if values[4] > 0 {
+		result.RetryAfter = time.Duration(values[4]) * time.Microsecond
+	}
+	if values[5] == 1 {
+		result.BlockedUntil = result.ResetAt
+	}
+	return result
+}
+
+// block stores the block of the client in Redis, where every replica checks it
+func (l *redisClientLimiter) block(by Rate, duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+
+	window := 1
+	for i, limit := range l.limits {
+		if limit.Rate == by {
+			window = i + 1
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := redisBlock.Run(ctx, l.client, []string{l.key}, window, duration.Microseconds()).Err(); err != nil {
+		log.Println("Error blocking client on Redis", l.key, err)
+	}
+}
@@ -0,0 +1,137 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/suite"
+)
+
+type RedisLimiterTestSuite struct {
+	suite.Suite
+	Now    time.Time
+	Server *miniredis.Miniredis
+	Client *redis.Client
+}
+
+func (suite *RedisLimiterTestSuite) SetupTest() {
+	suite.Now = time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	suite.Server = miniredis.RunT(suite.T())
+	suite.Server.SetTime(suite.Now)
+	suite.Client = redis.NewClient(&redis.Options{Addr: suite.Server.Addr()})
+}
+
+func (suite *RedisLimiterTestSuite) TearDownTest() {
+	suite.Client.Close()
+}
+
+func TestRedisLimiterSuite(t *testing.T) {
+	suite.Run(t, new(RedisLimiterTestSuite))
+}
+
+func (suite *RedisLimiterTestSuite) TestGivenSeveralWindows_WhenLongerWindowExhausted_ThenShouldDenyWithoutConsumingAnyWindow() {
+
+	perSecond := Rate{Count: 5, Period: time.Second}
+	perMinute := Rate{Count: 1, Period: time.Minute}
+	limiter := NewRedisLimiter(suite.Client, "test").newLimiter("127.0.0.1", []Limit{{Rate: perMinute}, {Rate: perSecond}})
+
+	result := limiter.AllowN(suite.Now, 1)
+	suite.True(result.Allowed)
+	suite.Equal(perMinute, result.Limit)
+	suite.Equal(1, result.Quota)
+	suite.Equal(0, result.Remaining)
+	suite.Equal(suite.Now.Add(time.Minute), result.ResetAt)
+
+	key := "test:limiter:127.0.0.1"
+	perSecondTat := suite.Server.HGet(key, "1")
+	perMinuteTat := suite.Server.HGet(key, "2")
+	suite.NotEmpty(perSecondTat)
+	suite.NotEmpty(perMinuteTat)
+	suite.Equal(61*time.Second, suite.Server.TTL(key))
+
+	for i := 0; i < 4; i++ {
+		result = limiter.AllowN(suite.Now, 1)
+		suite.False(result.Allowed)
+		suite.Equal(perMinute, result.Limit)
+		suite.Equal(time.Minute, result.RetryAfter)
+	}
+
+	suite.Equal(perSecondTat, suite.Server.HGet(key, "1"))
+	suite.Equal(perMinuteTat, suite.Server.HGet(key, "2"))
+}
+
+func (suite *RedisLimiterTestSuite) TestGivenCost_WhenAllowN_ThenShouldReportRemainingAndRetryAfter() {
+
+	limiter := NewRedisLimiter(suite.Client, "test").newLimiter("127.0.0.1", []Limit{{Rate: Rate{Count: 5, Period: time.Second}}})
+
+	result := limiter.AllowN(suite.Now, 3)
+	suite.True(result.Allowed)
+	suite.Equal(5, result.Quota)
+	suite.Equal(2, result.Remaining)
+
+	result = limiter.AllowN(suite.Now, 3)
+	suite.False(result.Allowed)
+	suite.Equal(200*time.Millisecond, result.RetryAfter)
+
+	result = limiter.AllowN(suite.Now, 6)
+	suite.False(result.Allowed)
+	suite.Zero(result.RetryAfter)
+
+	suite.Server.SetTime(suite.Now.Add(200 * time.Millisecond))
+	suite.True(limiter.AllowN(suite.Now, 3).Allowed)
+}
+
+func (suite *RedisLimiterTestSuite) TestGivenTwoReplicas_WhenAllowN_ThenShouldShareTheBudgetOfTheClient() {
+
+	other := redis.NewClient(&redis.Options{Addr: suite.Server.Addr()})
+	defer other.Close()
+
+	limits := func() []Limit { return []Limit{{Rate: Rate{Count: 2, Period: time.Second}}} }
+	replica := NewRedisLimiter(suite.Client, "test").newLimiter("127.0.0.1", limits())
+	otherReplica := NewRedisLimiter(other, "test").newLimiter("127.0.0.1", limits())
+
+	suite.True(replica.AllowN(suite.Now, 1).Allowed)
+	suite.True(otherReplica.AllowN(suite.Now, 1).Allowed)
+
+	result := replica.AllowN(suite.Now, 1)
+	suite.False(result.Allowed)
+	suite.Equal(500*time.Millisecond, result.RetryAfter)
+
+	suite.Server.SetTime(suite.Now.Add(500 * time.Millisecond))
+	suite.True(otherReplica.AllowN(suite.Now, 1).Allowed)
+	suite.False(replica.AllowN(suite.Now, 1).Allowed)
+}
+
+func (suite *RedisLimiterTestSuite) TestGivenBlockedClient_WhenAllowN_ThenShouldDenyOnEveryReplicaWithoutConsuming() {
+
+	other := redis.NewClient(&redis.Options{Addr: suite.Server.Addr()})
+	defer other.Close()
+
+	perSecond := Rate{Count: 5, Period: time.Second}
+	perMinute := Rate{Count: 100, Period: time.Minute}
+	limits := func() []Limit { return []Limit{{Rate: perSecond}, {Rate: perMinute}} }
+	replica := NewRedisLimiter(suite.Client, "test").newLimiter("127.0.0.1", limits())
+	otherReplica := NewRedisLimiter(other, "test").newLimiter("127.0.0.1", limits())
+
+	suite.True(replica.AllowN(suite.Now, 1).Allowed)
+	key := "test:limiter:127.0.0.1"
+	perSecondTat := suite.Server.HGet(key, "1")
+
+	replica.(blocker).block(perMinute, 30*time.Second)
+	suite.Equal(31*time.Second, suite.Server.TTL(key))
+
+	result := otherReplica.AllowN(suite.Now, 1)
+	suite.False(result.Allowed)
+	suite.Equal(perMinute, result.Limit)
+	suite.Equal(100, result.Quota)
+	suite.Equal(30*time.Second, result.RetryAfter)
+	suite.Equal(suite.Now.Add(30*time.Second), result.BlockedUntil)
+	suite.Equal(perSecondTat, suite.Server.HGet(key, "1"))
+
+	suite.Server.SetTime(suite.Now.Add(30 * time.Second))
+	result = otherReplica.AllowN(suite.Now, 1)
+	suite.True(result.Allowed)
+	suite.True(result.BlockedUntil.IsZero())
+}
@@ -0,0 +1,91 @@
+package ratelimiter
+
+import (
+	"log"
+	"time"
+
+	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+)
+
+// Request describes a request to the rate limiter. Key is the API key, or the
+// subject of a client authenticated elsewhere when Authenticated, limited by
+// its Plan. Policy names the route policy whose limits apply instead of the
+// client ones, and Cost is how many requests the request counts as (1 when
+// not set). Denied requests that fit within MaxWait are delayed
+type Request struct {
+	IpAddr        string
+	Key           string
+	Authenticated bool
+	Plan          string
+	Policy        string
+	Cost          int
+	MaxWait       time.Duration
+}
+
+func (request Request) cost() int {
+	if request.Cost < 1 {
+		return 1
+	}
+	return request.Cost
+}
+
+// DecideRequest verifies if the request is allowed, describing the limit
+// applied to the client. Unknown and expired keys are handled by the
+// UnknownKeyPolicy and ExpiredTokenPolicy
+func (r *RateLimiter) DecideRequest(request Request) Decision {
+
+	if request.Authenticated {
+
+		planConfig, ok := r.Configs.Plans[request.Plan]
+		if request.Key != "" && ok {
+
+			log.Println("plan", request.Plan, planConfig)
+			return r.decideToken(request)
+		}
+
+		return r.decideIp(request)
+	}
+
+	if request.Key != "" {
+
+		tokenConfig, ok := r.Configs.TokenConfigs[request.Key]
+		if !ok {
+			log.Println("Unknown token", request.Key)
+			return r.decideInvalidKey(request, UnknownKey, r.Configs.UnknownKeyPolicy)
+		}
+		if tokenConfig.expired(time.Now()) {
+			log.Printf("Token %s expired at %s\n", request.Key, tokenConfig.ExpiresAt)
+			return r.decideInvalidKey(request, TokenExpired, r.Configs.ExpiredTokenPolicy)
+		}
+
+		log.Println("tokenConfig", tokenConfig)
+		return r.decideToken(request)
+	}
+
+	return r.decideIp(request)
+}
+
+func (r *RateLimiter) decideIp(request Request) Decision {
+	log.Println("ipMaxReqsPerSecond", r.Configs.IpMaxReqsPerSecond, "ipRate", r.Configs.IpRate, "ipRates", r.Configs.IpRates)
+	return r.verifyClientAllowed(request.client(r.ipKey(request.IpAddr), entity.Ip), request)
+}
+
+// delays tells if the denied request fits within MaxWait
+func (request Request) delays(result Result) bool {
+	return !result.Allowed && result.RetryAfter > 0 && result.RetryAfter <= request.MaxWait
+}
+
+// client creates the client identified by id. Each route policy limits the
+// client apart, under its own id
+func (request Request) client(id string, clientType entity.ClientType) entity.ActiveClient {
+	plan := ""
+	if clientType == entity.Token {
+		plan = request.Plan
+	}
+	if request.Policy != "" {
+		id = request.Policy + ":" + id
+	}
+	client := createActiveClient(id, clientType, plan)
+	client.Policy = request.Policy
+	return client
+}
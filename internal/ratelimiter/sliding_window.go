@@ -0,0 +1,172 @@
+package ratelimiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLogAlgorithm keeps the time of every request inside the rolling
+// window, so no more than the limit is ever allowed in any window
+type SlidingWindowLogAlgorithm struct{}
+
+func (a SlidingWindowLogAlgorithm) NewLimiter(limit Limit) Limiter {
+	return &slidingWindowLogLimiter{
+		rate:   limit.Rate,
+		max:    limit.reqsPerWindow(),
+		window: limit.window(),
+	}
+}
+
+type slidingWindowLogLimiter struct {
+	mu       sync.Mutex
+	rate     Rate
+	max      int
+	window   time.Duration
+	requests []time.Time
+}
+
+func (l *slidingWindowLogLimiter) AllowN(now time.Time, n int) Result {
+	return l.apply(now, n, true)
+}
+
+func (l *slidingWindowLogLimiter) check(now time.Time, n int) Result {
+	return l.apply(now, n, false)
+}
+
+func (l *slidingWindowLogLimiter) apply(now time.Time, n int, consume bool) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// discard requests that left the window
+	windowStart := now.Add(-l.window)
+	expired := 0
+	for expired < len(l.requests) && !l.requests[expired].After(windowStart) {
+		expired++
+	}
+	l.requests = l.requests[expired:]
+
+	if len(l.requests)+n > l.max {
+		result := Result{Allowed: false, Limit: l.rate, Quota: l.max, ResetAt: now}
+		if len(l.requests) > 0 {
+			// the request fits once the oldest ones needed leave the window
+			if oldest := len(l.requests) + n - l.max - 1; oldest < len(l.requests) {
+				result.RetryAfter = l.requests[oldest].Add(l.window).Sub(now)
+			}
+			result.ResetAt = l.requests[len(l.requests)-1].Add(l.window)
+		}
+		return result
+	}
+
+	remaining := l.max - len(l.requests) - n
+	if consume {
+		// a request costing n is logged as n requests
+		for i := 0; i < n; i++ {
+			l.requests = append(l.requests, now)
+		}
+	}
+	return Result{
+		Allowed:   true,
+		Limit:     l.rate,
+		Quota:     l.max,
+		Remaining: remaining,
+		ResetAt:   now.Add(l.window),
+	}
+}
+
+// SlidingWindowCounterAlgorithm approximates the sliding window log weighting
+// the previous fixed window count by how much of it still overlaps the rolling window
+type SlidingWindowCounterAlgorithm struct{}
+
+func (a SlidingWindowCounterAlgorithm) NewLimiter(limit Limit) Limiter {
+	return &slidingWindowCounterLimiter{
+		rate:   limit.Rate,
+		max:    limit.reqsPerWindow(),
+		window: limit.window(),
+	}
+}
+
+type slidingWindowCounterLimiter struct {
+	mu            sync.Mutex
+	rate          Rate
+	max           int
+	window        time.Duration
+	windowStart   time.Time
+	count         int
+	previousCount int
+}
+
+func (l *slidingWindowCounterLimiter) AllowN(now time.Time, n int) Result {
+	return l.apply(now, n, true)
+}
+
+func (l *slidingWindowCounterLimiter) check(now time.Time, n int) Result {
+	return l.apply(now, n, false)
+}
+
+func (l *slidingWindowCounterLimiter) apply(now time.Time, n int, consume bool) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	windowStart := now.Truncate(l.window)
+	if !windowStart.Equal(l.windowStart) {
+		if windowStart.Sub(l.windowStart) == l.window {
+			l.previousCount = l.count
+		} else {
+			l.previousCount = 0
+		}
+		l.windowStart = windowStart
+		l.count = 0
+	}
+
+	overlap := 1 - float64(now.Sub(windowStart))/float64(l.window)
+	estimated := float64(l.previousCount)*overlap + float64(l.count)
+	resetAt := windowStart.Add(2 * l.window)
+
+	if estimated+float64(n) > float64(l.max) {
+		return Result{
+			Allowed:    false,
+			Limit:      l.rate,
+			Quota:      l.max,
+			ResetAt:    resetAt,
+			RetryAfter: l.retryAfter(now, n),
+		}
+	}
+
+	if consume {
+		l.count += n
+	}
+	return Result{
+		Allowed:   true,
+		Limit:     l.rate,
+		Quota:     l.max,
+		Remaining: int(math.Max(0, math.Floor(float64(l.max)-estimated-float64(n)))),
+		ResetAt:   resetAt,
+	}
+}
+
+// retryAfter finds when the weighted previous count decays enough to fit a
+// request costing n, in the current window or else in the next one
+func (l *slidingWindowCounterLimiter) retryAfter(now time.Time, n int) time.Duration {
+	if l.max < n {
+		return 0
+	}
+
+	windowStart := l.windowStart
+	previousCount, count := l.previousCount, l.count
+	if count+n > l.max {
+		windowStart = windowStart.Add(l.window)
+		previousCount, count = count, 0
+	}
+
+	elapsed := 0.0
+	if previousCount > 0 {
+		elapsed = math.Max(0, 1-float64(l.max-count-n)/float64(previousCount))
+	}
+
+	retryAt := windowStart.Add(time.Duration(math.Ceil(elapsed * float64(l.window))))
+	if retryAt.Before(now) {
+		return 0
+	}
+	return retryAt.Sub(now)
+}
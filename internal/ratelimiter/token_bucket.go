@@ -0,0 +1,57 @@
+package ratelimiter
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type TokenBucketAlgorithm struct{}
+
+func (a TokenBucketAlgorithm) NewLimiter(limit Limit) Limiter {
+	return &tokenBucketLimiter{
+		rate:    limit.Rate,
+		limiter: rate.NewLimiter(rate.Limit(limit.Rate.PerSecond()), limit.burst()),
+	}
+}
+
+type tokenBucketLimiter struct {
+	rate    Rate
+	limiter *rate.Limiter
+}
+
+func (l *tokenBucketLimiter) AllowN(now time.Time, n int) Result {
+	return l.result(now, n, l.limiter.AllowN(now, n))
+}
+
+func (l *tokenBucketLimiter) check(now time.Time, n int) Result {
+	return l.result(now, n, float64(n) <= l.limiter.TokensAt(now))
+}
+
+func (l *tokenBucketLimiter) result(now time.Time, n int, allowed bool) Result {
+	tokens := l.limiter.TokensAt(now)
+	burst := l.limiter.Burst()
+
+	result := Result{
+		Allowed:   allowed,
+		Limit:     l.rate,
+		Quota:     burst,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		ResetAt:   now.Add(l.timeToTokens(float64(burst) - tokens)),
+	}
+	// a request costing more than the burst never fits
+	if !allowed && n <= burst {
+		result.RetryAfter = l.timeToTokens(float64(n) - tokens)
+	}
+	return result
+}
+
+// timeToTokens returns how long the bucket takes to refill the given tokens
+func (l *tokenBucketLimiter) timeToTokens(tokens float64) time.Duration {
+	perSecond := float64(l.limiter.Limit())
+	if tokens <= 0 || perSecond <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens / perSecond * float64(time.Second)))
+}
@@ -0,0 +1,98 @@
+package ratelimiter
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	entity "github.com/regismartiny/go-expert-desafio-rate-limiter/internal/entity"
+)
+
+// defaultMaxIpsWindow is how long an IP counts towards MaxIps after its last request
+const defaultMaxIpsWindow = time.Hour
+
+// TokenIps tracks the IPs each token is used from, when they were last seen
+type TokenIps struct {
+	mu   sync.Mutex
+	seen map[string]map[string]time.Time
+}
+
+// allow records the IP of a token request, refusing new IPs once maxIps IPs
+// were seen within the window
+func (t *TokenIps) allow(token string, ip string, maxIps int, window time.Duration, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.seen == nil {
+		t.seen = make(map[string]map[string]time.Time)
+	}
+	ips, ok := t.seen[token]
+	if !ok {
+		ips = make(map[string]time.Time)
+		t.seen[token] = ips
+	}
+
+	for k, lastSeen := range ips {
+		if now.Sub(lastSeen) > window {
+			delete(ips, k)
+		}
+	}
+
+	if _, known := ips[ip]; !known && len(ips) >= maxIps {
+		return false
+	}
+	ips[ip] = now
+	return true
+}
+
+// decideToken verifies the request of a token client. Tokens with MaxIps are
+// refused from new IPs over the cap, and with EnforceIpLimit the IP limit also
+// applies. The IP is only charged for requests the token limit allows, and the
+// token only for requests the IP limit allows
+func (r *RateLimiter) decideToken(request Request) Decision {
+	id := request.Key
+	config := r.clientConfig(createActiveClient(id, entity.Token, request.Plan))
+
+	if config.MaxIps > 0 {
+		window := config.MaxIpsWindow
+		if window == 0 {
+			window = defaultMaxIpsWindow
+		}
+		if !r.tokenIps.allow(id, r.ipKey(request.IpAddr), config.MaxIps, window, time.Now()) {
+			log.Printf("Token %s used from more than %d IPs\n", id, config.MaxIps)
+			return newRejectedDecision(id, entity.Token, TooManyIps)
+		}
+	}
+
+	client := request.client(id, entity.Token)
+	if r.Configs.EnforceIpLimit && r.wouldAllow(client, request) {
+		decision := r.decideIp(request)
+		if !decision.Allowed {
+			return decision
+		}
+	}
+
+	return r.verifyClientAllowed(client, request)
+}
+
+// wouldAllow tells if the limits of the client allow the request, without
+// consuming them. Limiters unable to check without consuming, like the
+// distributed one, are assumed to allow it
+func (r *RateLimiter) wouldAllow(client entity.ActiveClient, request Request) bool {
+	now := time.Now()
+
+	r.activeClients.mu.Lock()
+	activeClient, exists := r.activeClients.clients[client.ClientId]
+	limiter := r.activeClients.limiters[client.ClientId]
+	r.activeClients.mu.Unlock()
+
+	if exists && activeClient.Blocked && now.Before(activeClient.BlockedUntil) {
+		return false
+	}
+	if !exists || activeClient.Plan != client.Plan {
+		limiter = r.getLimiter(client)
+	}
+
+	checker, ok := limiter.(checker)
+	return !ok || checker.check(now, request.cost()).Allowed
+}
@@ -0,0 +1,80 @@
+package ratelimiter
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// WaitQueues counts the requests each client has waiting
+type WaitQueues struct {
+	mu      sync.Mutex
+	waiting map[string]int
+}
+
+// enter adds a waiting request of the client, unless maxQueue are already waiting
+func (q *WaitQueues) enter(id string, maxQueue int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.waiting == nil {
+		q.waiting = make(map[string]int)
+	}
+	if maxQueue > 0 && q.waiting[id] >= maxQueue {
+		return false
+	}
+	q.waiting[id]++
+	return true
+}
+
+func (q *WaitQueues) leave(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.waiting[id]--
+	if q.waiting[id] <= 0 {
+		delete(q.waiting, id)
+	}
+}
+
+// Wait holds the request until the client limits allow it, for up to its
+// MaxWait. Requests that would wait longer are denied as usual, and requests
+// over the MaxQueue of the client or whose context ends are denied without
+// blocking the client. Delayed attempts consume no limit, so a token request
+// waiting under EnforceIpLimit charges its IP once
+func (r *RateLimiter) Wait(ctx context.Context, request Request) Decision {
+	deadline := time.Now().Add(request.MaxWait)
+	queued := ""
+	defer func() {
+		if queued != "" {
+			r.waitQueues.leave(queued)
+		}
+	}()
+
+	for {
+		request.MaxWait = time.Until(deadline)
+		decision := r.DecideRequest(request)
+		if decision.Reason != Delayed {
+			return decision
+		}
+
+		if queued == "" {
+			if !r.waitQueues.enter(decision.ClientId, r.Configs.MaxQueue) {
+				log.Printf("Wait queue of client %s is full\n", decision.ClientId)
+				decision.Reason = QueueFull
+				return decision
+			}
+			queued = decision.ClientId
+		}
+
+		timer := time.NewTimer(decision.RetryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			decision.Reason = Canceled
+			return decision
+		case <-timer.C:
+		}
+	}
+}